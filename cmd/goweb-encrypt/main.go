@@ -0,0 +1,72 @@
+// Command goweb-encrypt produces enc:<base64> values for goweb config
+// files.
+//
+//	goweb-encrypt -genkey > secret.key
+//	GOWEB_SECRET_KEYFILE=secret.key goweb-encrypt 'root:pw@tcp(db:3306)/base'
+//
+// The value is taken from the arguments, or from standard input if there
+// are none, and the key from GOWEB_SECRET_KEY or GOWEB_SECRET_KEYFILE as
+// at load time, unless -keyfile names one. -decrypt reverses it.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/puzhengwu/goweb"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("goweb-encrypt: ")
+	genkey := flag.Bool("genkey", false, "print a new random AES-256 key")
+	decrypt := flag.Bool("decrypt", false, "decrypt an enc: value instead")
+	keyfile := flag.String("keyfile", "", "file holding the key, instead of GOWEB_SECRET_KEY or GOWEB_SECRET_KEYFILE")
+	flag.Parse()
+
+	if *genkey {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return
+	}
+
+	if *keyfile != "" {
+		os.Unsetenv("GOWEB_SECRET_KEY")
+		os.Setenv("GOWEB_SECRET_KEYFILE", *keyfile)
+	}
+	key, err := goweb.ConfigSecretKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if key == nil {
+		log.Fatal("no key; set GOWEB_SECRET_KEY or GOWEB_SECRET_KEYFILE, or use -keyfile")
+	}
+
+	value := strings.Join(flag.Args(), " ")
+	if flag.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if *decrypt {
+		value, err = goweb.DecryptConfigValue(key, value)
+	} else {
+		value, err = goweb.EncryptConfigValue(key, value)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(value)
+}
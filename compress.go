@@ -0,0 +1,286 @@
+package goweb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CompressOptions configures the middleware returned by Compress.
+type CompressOptions struct {
+	// Level is the gzip/deflate compression level from 1 to 9, or
+	// gzip.HuffmanOnly (-2); zero, and any other value, means
+	// gzip.DefaultCompression.
+	Level int
+	// MinSize is the smallest body worth compressing, default 1024 bytes.
+	MinSize int
+	// ContentTypes lists the media types, or prefixes ending in "/", that
+	// may be compressed. The default covers text, JSON, JavaScript, XML
+	// and SVG.
+	ContentTypes []string
+}
+
+var defaultCompressTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// Compress returns a middleware that gzip or deflate encodes responses
+// for clients that accept it. Bodies are buffered only until MinSize is
+// reached, so streamed responses keep streaming. Because it wraps
+// ctx.ResponseWriter it applies to static files and every transport.
+func Compress(opts *CompressOptions) Middleware {
+	o := CompressOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Level == 0 || !validCompressLevel(o.Level) {
+		o.Level = gzip.DefaultCompression
+	}
+	if o.MinSize <= 0 {
+		o.MinSize = 1024
+	}
+	if len(o.ContentTypes) == 0 {
+		o.ContentTypes = defaultCompressTypes
+	}
+
+	return func(ctx *Context, next func()) {
+		addVary(ctx.Header(), "Accept-Encoding")
+		encoding := negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"))
+		if encoding == "" || ctx.Request.Method == "HEAD" {
+			next()
+			return
+		}
+		cw := &compressWriter{ResponseWriter: ctx.ResponseWriter, opts: &o, encoding: encoding}
+		ctx.ResponseWriter = cw
+		defer cw.close()
+		next()
+	}
+}
+
+func validCompressLevel(level int) bool {
+	return level >= gzip.HuffmanOnly && level <= gzip.BestCompression
+}
+
+// compressWriter holds back the first MinSize bytes of the body to decide
+// whether compressing is worthwhile, then either encodes or passes
+// everything through.
+type compressWriter struct {
+	http.ResponseWriter
+	opts     *CompressOptions
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	w.status = status
+	if status != http.StatusOK {
+		w.decide()
+	} else if cl := w.Header().Get("Content-Length"); cl != "" {
+		//the size is known up front, no need to buffer
+		w.decide()
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.opts.MinSize {
+			return len(p), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far, compressing it if the
+// response qualifies.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decide()
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		//the connection is handed over untouched
+		w.decided = true
+		return h.Hijack()
+	}
+	return nil, nil, ErrHijackNotSupported
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// newEncoder sets up w.enc, reporting whether it could; the body is
+// sent unencoded otherwise.
+func (w *compressWriter) newEncoder() bool {
+	var err error
+	if w.encoding == "gzip" {
+		var zw *gzip.Writer
+		zw, err = gzip.NewWriterLevel(w.ResponseWriter, w.opts.Level)
+		if err == nil {
+			w.enc = zw
+		}
+	} else {
+		var zw *zlib.Writer
+		zw, err = zlib.NewWriterLevel(w.ResponseWriter, w.opts.Level)
+		if err == nil {
+			w.enc = zw
+		}
+	}
+	return err == nil
+}
+
+// decide writes the header, choosing the encoding, and releases any
+// buffered bytes.
+func (w *compressWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	if w.shouldCompress() && w.newEncoder() {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			//the encoded bytes differ from what the tag was computed on
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) shouldCompress() bool {
+	h := w.Header()
+	if w.status != http.StatusOK || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	size := len(w.buf)
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		size = cl
+	}
+	if size < w.opts.MinSize {
+		return false
+	}
+	ctype := h.Get("Content-Type")
+	if ctype == "" {
+		ctype = http.DetectContentType(w.buf)
+		h.Set("Content-Type", ctype)
+	}
+	return compressibleType(ctype, w.opts.ContentTypes)
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			//nothing was written
+			return
+		}
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.decide()
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
+
+func compressibleType(ctype string, allowed []string) bool {
+	if i := strings.IndexByte(ctype, ';'); i >= 0 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	for _, t := range allowed {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(ctype, t) || ctype == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// honouring q-values, or returns "" if neither is acceptable.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if coding == "*" {
+			coding = "gzip"
+		}
+		if coding != "gzip" && coding != "deflate" || q <= 0 {
+			continue
+		}
+		//prefer gzip on ties
+		if q > bestQ || q == bestQ && coding == "gzip" {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// addVary adds value to the Vary header unless it is already listed.
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,54 @@
+package goweb
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCompressEventStream streams events with a fast heartbeat through
+// the compress middleware and a middleware that reads the response
+// after the handler; run with -race.
+func TestCompressEventStream(t *testing.T) {
+	s := NewServer(nil)
+	s.Logger = log.New(io.Discard, "", 0)
+	s.Use(func(ctx *Context, next func()) {
+		next()
+		ctx.Log(ctx.writer.Status(), ctx.writer.Size())
+	})
+	s.Use(Compress(&CompressOptions{MinSize: 1}))
+	s.Get("/events", func(ctx *Context) {
+		es := ctx.SSE()
+		es.Heartbeat(time.Microsecond)
+		es.Send("", "hello")
+		time.Sleep(5 * time.Millisecond)
+		if ctx.Params["panic"] != "" {
+			panic("boom")
+		}
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	for _, uri := range []string{"/events", "/events?panic=1"} {
+		for i := 0; i < 10; i++ {
+			req, _ := http.NewRequest("GET", srv.URL+uri, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Header.Get("Content-Type") != "text/event-stream" || !strings.Contains(string(body), "data: hello\n\n") {
+				t.Fatalf("%s: got %q: %q", uri, resp.Header.Get("Content-Type"), body)
+			}
+		}
+	}
+}
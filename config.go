@@ -2,45 +2,503 @@ package goweb
 
 import (
 	"bufio"
+	"flag"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 const configFile = "conf/app.conf"
 
+var (
+	configPath string
+	configFlag *string
+)
+
+// SetConfigFile sets the path NewConfig and the default server read
+// their config from.
+func SetConfigFile(path string) {
+	configPath = path
+}
+
+// ConfigFlag defines a -goweb.config flag on fs, or on flag.CommandLine
+// if fs is nil, for choosing the config file from the command line. Call
+// it before parsing the flags:
+//
+//	goweb.ConfigFlag(nil)
+//	flag.Parse()
+func ConfigFlag(fs *flag.FlagSet) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	configFlag = fs.String("goweb.config", "", "path of the goweb config file")
+}
+
+// ConfigFile returns the config file in use: the path given to
+// SetConfigFile, else the flag defined by ConfigFlag (once it is
+// parsed), else the GOWEB_CONFIG environment variable, else
+// conf/app.conf.
+func ConfigFile() string {
+	if configPath != "" {
+		return configPath
+	}
+	if configFlag != nil && *configFlag != "" {
+		return *configFlag
+	}
+	if env := os.Getenv("GOWEB_CONFIG"); env != "" {
+		return env
+	}
+	return configFile
+}
+
+// Config is a parsed INI file. The root Config answers for every key in
+// the file, whatever its section, as it always has; Section narrows the
+// lookup to one section so that keys with the same name in different
+// sections no longer collide.
 type Config struct {
 	keys map[string]string
+	// lines and files record where each key was set, for error
+	// messages and Source.
+	lines    map[string]int
+	files    map[string]string
+	file     string
+	name     string
+	sections map[string]*Config
+	order    []string
+	// global holds the keys set outside any section, which a section
+	// key of the same name shadows in the flattened view.
+	global *Config
+	// includes are the files named by include directives, still to be
+	// loaded; loaded lists every file that went into the config.
+	includes []configInclude
+	loaded   []string
+}
+
+// ConfigError reports a problem in a config file.
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+// Error formats the error as file:line: msg. Line is zero for keys that
+// are missing or were set from the environment.
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func newConfig(file string, name string) *Config {
+	return &Config{
+		keys:  make(map[string]string),
+		lines: make(map[string]int),
+		files: make(map[string]string),
+		file:  file,
+		name:  name,
+	}
 }
 
+// NewConfig loads the file named by ConfigFile with its layers, as
+// described for LoadLayeredConfig.
 func NewConfig() (*Config, error) {
-	cfg := &Config{
-		make(map[string]string),
+	return LoadLayeredConfig(ConfigFile())
+}
+
+// LoadConfig reads and parses filename, choosing the format by its
+// extension: .json, .toml, .yaml or .yml, and INI for anything else.
+// Every format maps onto the same sections and keys: top-level tables
+// are sections, deeper tables become dotted keys and lists become
+// comma-separated values. String values expand ${VAR} and the GOWEB_
+// environment overrides apply, as described for ParseConfig.
+func LoadConfig(filename string) (*Config, error) {
+	cfg, err := loadConfigFile(filename, nil)
+	if err != nil {
+		return nil, err
 	}
+	cfg.applyEnv(os.Environ())
+	if err := cfg.decryptSecrets(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	file, err := os.Open(configFile)
+// parseConfigFile parses r in the format filename's extension calls
+// for, leaving includes unresolved and the environment unapplied.
+func parseConfigFile(r io.Reader, filename string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return parseConfigFormat(r, filename, parseJSONConfig)
+	case ".toml":
+		return parseConfigFormat(r, filename, parseTOMLConfig)
+	case ".yaml", ".yml":
+		return parseConfigFormat(r, filename, parseYAMLConfig)
+	}
+	return parseINI(r, filename)
+}
+
+// ParseConfig parses INI text from r; filename is used in errors.
+//
+// Keys are set with "key = value" and grouped under "[Section]" headers,
+// whose names are matched case-insensitively. Lines starting with ";" or
+// "#" are comments, as is the rest of a line after " ;" or " #". Values
+// may be quoted with "..." (which understands \", \\, \n and \t escapes)
+// or '...' to keep spaces and comment characters, and an unquoted value
+// ending in "\" continues on the next line. Setting a key twice in one
+// section is an error, and so is a line that is not a comment, a section
+// header or a key = value pair; such lines used to be skipped silently.
+//
+// "include = path" before the first section loads another file first,
+// relative to this one; the keys set here override the included ones.
+// A file may have several includes, applied in order.
+//
+// Unquoted and double-quoted values expand ${VAR} to the environment
+// variable VAR, and ${VAR:-default} to default when VAR is unset or
+// empty; write $${ for a literal "${". Single-quoted values are taken
+// as is.
+//
+// Finally the environment overrides the file: GOWEB_<SECTION>_<KEY>
+// sets key in [Section], and GOWEB_<KEY> a key outside any section. Names
+// are upper-cased with anything but letters and digits turned into "_",
+// so dbconn in [Mysql] is GOWEB_MYSQL_DBCONN. A variable naming a key
+// the file does not have adds it, to the section its name starts with
+// if there is one. Values of the form enc:<base64>, from the file or
+// the environment, are then decrypted with ConfigSecretKey.
+func ParseConfig(r io.Reader, filename string) (*Config, error) {
+	cfg, err := parseINI(r, filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	if cfg, err = cfg.resolveIncludes(nil); err != nil {
+		return nil, err
+	}
+	cfg.applyEnv(os.Environ())
+	if err := cfg.decryptSecrets(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	inputread := bufio.NewReader(file)
+func parseINI(r io.Reader, filename string) (*Config, error) {
+	cfg := newConfig(filename, "")
+	section := cfg
 
-	for {
-		input, _, err := inputread.ReadLine()
-		if err == io.EOF {
-			break
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		start := lineno
+		for strings.HasSuffix(line, "\\") && !isQuoted(line) && scanner.Scan() {
+			lineno++
+			line = strings.TrimSpace(line[:len(line)-1]) + " " + strings.TrimSpace(scanner.Text())
+		}
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			name := ""
+			if end > 0 {
+				name = strings.TrimSpace(line[1:end])
+			}
+			if name == "" || strings.TrimSpace(stripComment(line[end+1:])) != "" {
+				return nil, &ConfigError{filename, start, "malformed section header " + line}
+			}
+			section = cfg.addSection(name)
+			continue
 		}
-		line := string(input)
+
 		keyval := strings.SplitN(line, "=", 2)
-		if len(keyval) == 2 {
-			cfg.keys[strings.TrimSpace(keyval[0])] = strings.TrimSpace(keyval[1])
+		key := strings.TrimSpace(keyval[0])
+		if len(keyval) != 2 || key == "" {
+			return nil, &ConfigError{filename, start, "expected key = value, got " + line}
+		}
+		value, err := parseConfigValue(strings.TrimSpace(keyval[1]))
+		if err != nil {
+			return nil, &ConfigError{filename, start, err.Error()}
+		}
+		if section == cfg && strings.EqualFold(key, "include") {
+			cfg.includes = append(cfg.includes, configInclude{value, start})
+			continue
+		}
+		if err := cfg.add(section, key, value, start); err != nil {
+			return nil, err
 		}
 	}
-
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// add sets key in section, which is c itself or one of its sections,
+// refusing duplicates. The root keeps a flattened view of every section.
+func (c *Config) add(section *Config, key string, value string, line int) error {
+	own := section
+	if section == c {
+		own = c.globals()
+	}
+	if first, dup := own.lines[key]; dup {
+		where := ""
+		if section.name != "" {
+			where = " in [" + section.name + "]"
+		}
+		return &ConfigError{own.files[key], line, fmt.Sprintf("duplicate key %q%s, first set on line %d", key, where, first)}
+	}
+	if section == c {
+		c.setGlobal(key, value, c.file, line)
+		return nil
+	}
+	section.set(key, value, line)
+	c.set(key, value, line)
+	return nil
+}
+
+// globals returns the keys set outside any section.
+func (c *Config) globals() *Config {
+	if c.global == nil {
+		c.global = newConfig(c.file, "")
+	}
+	return c.global
+}
+
+// setGlobal sets a key outside any section.
+func (c *Config) setGlobal(key string, value string, file string, line int) {
+	c.globals().setFrom(key, value, file, line)
+	c.setFrom(key, value, file, line)
+}
+
+func (c *Config) set(key string, value string, line int) {
+	c.setFrom(key, value, c.file, line)
+}
+
+func (c *Config) setFrom(key string, value string, file string, line int) {
+	c.keys[key] = value
+	c.lines[key] = line
+	c.files[key] = file
+}
+
+func (c *Config) addSection(name string) *Config {
+	lname := strings.ToLower(name)
+	if s, ok := c.sections[lname]; ok {
+		return s
+	}
+	if c.sections == nil {
+		c.sections = make(map[string]*Config)
+	}
+	s := newConfig(c.file, name)
+	c.sections[lname] = s
+	c.order = append(c.order, name)
+	return s
+}
+
+// isQuoted reports whether line's value is quoted, so a trailing
+// backslash belongs to it rather than marking a continuation.
+func isQuoted(line string) bool {
+	if i := strings.IndexByte(line, '='); i >= 0 {
+		v := strings.TrimSpace(line[i+1:])
+		return v != "" && (v[0] == '"' || v[0] == '\'')
+	}
+	return false
+}
+
+func parseConfigValue(v string) (string, error) {
+	if v == "" || v[0] != '"' && v[0] != '\'' {
+		return expandEnv(strings.TrimSpace(stripComment(v)))
+	}
+	quote := v[0]
+	var buf strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == quote:
+			if rest := strings.TrimSpace(v[i+1:]); rest != "" && rest[0] != ';' && rest[0] != '#' {
+				return "", fmt.Errorf("unexpected %q after quoted value", rest)
+			}
+			if quote == '\'' {
+				return buf.String(), nil
+			}
+			return expandEnv(buf.String())
+		case c == '\\' && quote == '"' && i+1 < len(v):
+			i++
+			switch v[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			default:
+				buf.WriteByte(v[i])
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted value %s", v)
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} with values from the
+// environment.
+func expandEnv(v string) (string, error) {
+	if !strings.Contains(v, "${") {
+		return v, nil
+	}
+	var buf strings.Builder
+	for {
+		i := strings.Index(v, "${")
+		if i < 0 {
+			buf.WriteString(v)
+			return buf.String(), nil
+		}
+		if i > 0 && v[i-1] == '$' {
+			//$${ is a literal ${
+			buf.WriteString(v[:i-1] + "${")
+			v = v[i+2:]
+			continue
+		}
+		buf.WriteString(v[:i])
+		end := strings.IndexByte(v[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %s", v[i:])
+		}
+		name, def, hasDef := strings.Cut(v[i+2:i+end], ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %s", v[i:i+end+1])
+		}
+		value := os.Getenv(name)
+		if value == "" && hasDef {
+			value = def
+		}
+		buf.WriteString(value)
+		v = v[i+end+1:]
+	}
+}
+
+// reservedEnv are GOWEB_ variables that configure goweb itself rather
+// than override a key.
+var reservedEnv = map[string]bool{
+	"GOWEB_CONFIG":         true,
+	"GOWEB_ENV":            true,
+	"GOWEB_SECRET_KEY":     true,
+	"GOWEB_SECRET_KEYFILE": true,
+}
+
+// envName is the variable that overrides key in section.
+func envName(section string, key string) string {
+	name := "GOWEB_"
+	if section != "" {
+		name += section + "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name+key)
+}
+
+// applyEnv applies GOWEB_ overrides from environ, a list of NAME=value
+// pairs as returned by os.Environ.
+func (c *Config) applyEnv(environ []string) {
+	known := map[string]func(value string){}
+	for _, name := range c.order {
+		section := c.sections[strings.ToLower(name)]
+		for key := range section.keys {
+			section, key := section, key
+			env := envName(name, key)
+			known[env] = func(value string) {
+				section.setFrom(key, value, "$"+env, 0)
+				c.setFrom(key, value, "$"+env, 0)
+			}
+		}
+	}
+	for key := range c.keys {
+		if env := envName("", key); known[env] == nil {
+			key := key
+			known[env] = func(value string) { c.setGlobal(key, value, "$"+env, 0) }
+		}
+	}
+
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, "GOWEB_") || reservedEnv[name] {
+			continue
+		}
+		if set, ok := known[name]; ok {
+			set(value)
+			continue
+		}
+		key := strings.ToLower(name[len("GOWEB_"):])
+		target := c
+		for _, sname := range c.order {
+			if prefix := envName(sname, ""); strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+				target = c.sections[strings.ToLower(sname)]
+				key = strings.ToLower(name[len(prefix):])
+				break
+			}
+		}
+		if target == c {
+			c.setGlobal(key, value, "$"+name, 0)
+			continue
+		}
+		target.setFrom(key, value, "$"+name, 0)
+		c.setFrom(key, value, "$"+name, 0)
+	}
+}
+
+// stripComment removes a trailing " ;" or " #" comment.
+func stripComment(v string) string {
+	for i := 1; i < len(v); i++ {
+		if (v[i] == ';' || v[i] == '#') && (v[i-1] == ' ' || v[i-1] == '\t') {
+			return v[:i]
+		}
+	}
+	return v
+}
+
+// Section returns the keys under the [name] header, matched
+// case-insensitively. A missing section is returned empty, so lookups on
+// it fall back to their defaults.
+func (c *Config) Section(name string) *Config {
+	if s, ok := c.sections[strings.ToLower(name)]; ok {
+		return s
+	}
+	return newConfig(c.file, name)
+}
+
+// serverKeys returns the keys the server settings are read from: those
+// set outside any section, overridden by a [server] section, so that
+// keys such as port in [Mysql] do not leak into them.
+func (c *Config) serverKeys() *Config {
+	view := newConfig(c.file, "")
+	for _, from := range []*Config{c.global, c.sections["server"]} {
+		if from == nil {
+			continue
+		}
+		for key, value := range from.keys {
+			view.setFrom(key, value, from.files[key], from.lines[key])
+		}
+	}
+	return view
+}
+
+// Sections returns the section names in the order they appear.
+func (c *Config) Sections() []string {
+	return append([]string(nil), c.order...)
+}
+
+// Name returns the section's name, or "" for the root.
+func (c *Config) Name() string {
+	return c.name
+}
+
 func (c *Config) GetString(key string, defaultvalue string) string {
 	return ToString(c.keys[key], defaultvalue)
 }
@@ -53,6 +511,23 @@ func (c *Config) GetBool(key string, defaultvalue bool) bool {
 	return ToBool(c.keys[key], defaultvalue)
 }
 
+// GetList splits a comma-separated value, trimming each item and
+// dropping empty ones.
+func (c *Config) GetList(key string, defaultvalue []string) []string {
+	var list []string
+	for _, item := range strings.Split(c.keys[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return defaultvalue
+	}
+	return list
+}
+
+// GetMap returns the keys as a map. On the root Config it holds every
+// key in the file, with later sections winning on collisions.
 func (c *Config) GetMap() map[string]string {
 	return c.keys
 }
@@ -0,0 +1,921 @@
+package goweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON, TOML and YAML files are parsed into a tree of configNodes and
+// then mapped onto the INI model: scalars at the top level are keys
+// outside any section, tables at the top level are sections, tables
+// nested deeper become dotted keys ("pool.max") in their section, and
+// lists of scalars become comma-separated values, read back with
+// GetList or a slice field in Decode, so list items cannot contain
+// commas. A top-level "include", a path or a list of paths, works as it
+// does in an INI file.
+
+type configNode struct {
+	line int
+	// scalar is set for scalars, list for lists and keys/fields for
+	// tables.
+	scalar string
+	list   []*configNode
+	isList bool
+	keys   []string
+	fields map[string]*configNode
+	// defined is set on a TOML table once a [header] or inline table
+	// has defined it, rather than a deeper header implying it.
+	defined bool
+}
+
+func newTableNode(line int) *configNode {
+	return &configNode{line: line, fields: map[string]*configNode{}}
+}
+
+func (n *configNode) isTable() bool {
+	return n.fields != nil
+}
+
+// put adds key to the table, refusing duplicates.
+func (n *configNode) put(key string, child *configNode) error {
+	if first, ok := n.fields[key]; ok {
+		return fmt.Errorf("duplicate key %q, first set on line %d", key, first.line)
+	}
+	n.keys = append(n.keys, key)
+	n.fields[key] = child
+	return nil
+}
+
+// configParser turns a file into a tree, reporting errors as
+// ConfigErrors.
+type configParser func(data []byte, filename string) (*configNode, error)
+
+func parseConfigFormat(r io.Reader, filename string, parse configParser) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parse(data, filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newConfig(filename, "")
+	for _, key := range root.keys {
+		node := root.fields[key]
+		if key == "include" && !node.isTable() {
+			includes := []*configNode{node}
+			if node.isList {
+				includes = node.list
+			}
+			for _, inc := range includes {
+				if inc.isList || inc.isTable() {
+					return nil, &ConfigError{filename, inc.line, "include must be a path or a list of paths"}
+				}
+				cfg.includes = append(cfg.includes, configInclude{inc.scalar, inc.line})
+			}
+			continue
+		}
+		if node.isTable() {
+			if err := cfg.addTable(cfg.addSection(key), "", node); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := cfg.addNode(cfg, key, node); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func (c *Config) addTable(section *Config, prefix string, table *configNode) error {
+	for _, key := range table.keys {
+		node := table.fields[key]
+		if node.isTable() {
+			if err := c.addTable(section, prefix+key+".", node); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.addNode(section, prefix+key, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) addNode(section *Config, key string, node *configNode) error {
+	value := node.scalar
+	if node.isList {
+		items := make([]string, len(node.list))
+		for i, item := range node.list {
+			if item.isList || item.isTable() {
+				return &ConfigError{section.file, item.line, fmt.Sprintf("%s: only lists of plain values are supported", key)}
+			}
+			if strings.Contains(item.scalar, ",") {
+				//it would come back from GetList as several items
+				return &ConfigError{section.file, item.line, fmt.Sprintf("%s: list item %q contains a comma", key, item.scalar)}
+			}
+			items[i] = item.scalar
+		}
+		value = strings.Join(items, ", ")
+	}
+	return c.add(section, key, value, node.line)
+}
+
+// JSON
+
+func parseJSONConfig(data []byte, filename string) (*configNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	// lineOf finds the line of the token after the decoder's offset.
+	lineOf := func() int {
+		off := int(dec.InputOffset())
+		for off < len(data) && strings.IndexByte(" \t\r\n,:", data[off]) >= 0 {
+			off++
+		}
+		return 1 + bytes.Count(data[:off], []byte("\n"))
+	}
+	fail := func(line int, err error) error {
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			line = 1 + bytes.Count(data[:syntax.Offset], []byte("\n"))
+		}
+		return &ConfigError{filename, line, err.Error()}
+	}
+
+	var value func() (*configNode, error)
+	value = func() (*configNode, error) {
+		line := lineOf()
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fail(line, err)
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			if t == '[' {
+				n := &configNode{line: line, isList: true}
+				for dec.More() {
+					item, err := value()
+					if err != nil {
+						return nil, err
+					}
+					n.list = append(n.list, item)
+				}
+				if _, err := dec.Token(); err != nil {
+					return nil, fail(lineOf(), err)
+				}
+				return n, nil
+			}
+			n := newTableNode(line)
+			for dec.More() {
+				keyLine := lineOf()
+				key, err := dec.Token()
+				if err != nil {
+					return nil, fail(keyLine, err)
+				}
+				child, err := value()
+				if err != nil {
+					return nil, err
+				}
+				child.line = keyLine
+				if err := n.put(key.(string), child); err != nil {
+					return nil, &ConfigError{filename, keyLine, err.Error()}
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, fail(lineOf(), err)
+			}
+			return n, nil
+		case string:
+			s, err := expandEnv(t)
+			if err != nil {
+				return nil, &ConfigError{filename, line, err.Error()}
+			}
+			return &configNode{line: line, scalar: s}, nil
+		case json.Number:
+			return &configNode{line: line, scalar: jsonNumber(t)}, nil
+		case bool:
+			return &configNode{line: line, scalar: strconv.FormatBool(t)}, nil
+		}
+		//null
+		return &configNode{line: line}, nil
+	}
+
+	root, err := value()
+	if err != nil {
+		return nil, err
+	}
+	if !root.isTable() {
+		return nil, &ConfigError{filename, root.line, "the top level must be an object"}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &ConfigError{filename, lineOf(), "unexpected data after the top-level object"}
+	}
+	return root, nil
+}
+
+// jsonNumber formats n as written, except that a whole number written
+// as a float, such as 1e3 or 1.0, becomes an integer GetInt can read.
+func jsonNumber(n json.Number) string {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		return s
+	}
+	f, err := n.Float64()
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1<<63 {
+		return s
+	}
+	return strconv.FormatInt(int64(f), 10)
+}
+
+// TOML
+
+// tomlParser reads the parts of TOML a config file needs: tables, dotted
+// and quoted keys, basic, literal and multi-line strings, numbers,
+// booleans, dates (kept as text), arrays and inline tables. Arrays of
+// tables are not supported.
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+	file string
+}
+
+func parseTOMLConfig(data []byte, filename string) (*configNode, error) {
+	p := &tomlParser{src: string(data), line: 1, file: filename}
+	root := newTableNode(1)
+	table := root
+	for {
+		p.skipSpaceAndNewlines()
+		if p.eof() {
+			return root, nil
+		}
+		line := p.line
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.src[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skipSpace()
+			path, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.eof() || p.peek() != ']' {
+				return nil, p.errorf("expected ] after table name")
+			}
+			p.pos++
+			if table, err = p.table(root, path, line); err != nil {
+				return nil, err
+			}
+			if table.defined {
+				return nil, p.errorf("table [%s] is already defined on line %d", strings.Join(path, "."), table.line)
+			}
+			table.defined, table.line = true, line
+		} else if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &ConfigError{p.file, p.line, fmt.Sprintf(format, args...)}
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipSpaceAndNewlines skips blank lines and comments.
+func (p *tomlParser) skipSpaceAndNewlines() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if !p.eof() && p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if !p.eof() && p.peek() == '\r' {
+		p.pos++
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return p.errorf("unexpected %q", p.rest())
+	}
+	return nil
+}
+
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return p.src[p.pos:]
+	}
+	return strings.TrimSpace(p.src[p.pos : p.pos+end])
+}
+
+// table finds or creates the table at path for a [header].
+func (p *tomlParser) table(root *configNode, path []string, line int) (*configNode, error) {
+	t := root
+	for _, name := range path {
+		child, ok := t.fields[name]
+		if !ok {
+			child = newTableNode(line)
+			t.put(name, child)
+		} else if !child.isTable() {
+			return nil, p.errorf("%q is already set to a value on line %d", name, child.line)
+		}
+		t = child
+	}
+	return t, nil
+}
+
+// key reads a possibly dotted key.
+func (p *tomlParser) key() ([]string, error) {
+	var path []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("expected a key")
+		}
+		var part string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key, got %q", p.rest())
+			}
+			part = p.src[start:p.pos]
+		}
+		path = append(path, part)
+		p.skipSpace()
+		if p.eof() || p.peek() != '.' {
+			return path, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) keyValue(table *configNode) error {
+	line := p.line
+	path, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.eof() || p.peek() != '=' {
+		return p.errorf("expected = after key %s", strings.Join(path, "."))
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	value.line = line
+	t, err := p.table(table, path[:len(path)-1], line)
+	if err != nil {
+		return err
+	}
+	if err := t.put(path[len(path)-1], value); err != nil {
+		return &ConfigError{p.file, line, err.Error()}
+	}
+	return nil
+}
+
+func (p *tomlParser) value() (*configNode, error) {
+	if p.eof() {
+		return nil, p.errorf("expected a value")
+	}
+	line := p.line
+	switch c := p.peek(); c {
+	case '"':
+		s, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		if s, err = expandEnv(s); err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return &configNode{line: line, scalar: s}, nil
+	case '\'':
+		s, err := p.str()
+		return &configNode{line: line, scalar: s}, err
+	case '[':
+		p.pos++
+		n := &configNode{line: line, isList: true}
+		for {
+			p.skipSpaceAndNewlines()
+			if p.eof() {
+				return nil, p.errorf("unterminated array")
+			}
+			if p.peek() == ']' {
+				p.pos++
+				return n, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, item)
+			p.skipSpaceAndNewlines()
+			if !p.eof() && p.peek() == ',' {
+				p.pos++
+			} else if p.eof() || p.peek() != ']' {
+				return nil, p.errorf("expected , or ] in array")
+			}
+		}
+	case '{':
+		p.pos++
+		n := newTableNode(line)
+		n.defined = true
+		for {
+			p.skipSpace()
+			if p.eof() {
+				return nil, p.errorf("unterminated inline table")
+			}
+			if p.peek() == '}' {
+				p.pos++
+				return n, nil
+			}
+			if err := p.keyValue(n); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.eof() && p.peek() == ',' {
+				p.pos++
+			} else if p.eof() || p.peek() != '}' {
+				return nil, p.errorf("expected , or } in inline table")
+			}
+		}
+	}
+	//numbers, booleans and dates run to the next delimiter
+	start := p.pos
+	for !p.eof() && strings.IndexByte(",]}#\r\n", p.peek()) < 0 {
+		p.pos++
+	}
+	raw := strings.TrimSpace(p.src[start:p.pos])
+	switch {
+	case raw == "true" || raw == "false":
+	case raw == "":
+		return nil, p.errorf("expected a value")
+	case raw[0] == '+' || raw[0] == '-' || raw[0] >= '0' && raw[0] <= '9' || raw == "inf" || raw == "nan":
+		if !strings.HasPrefix(raw, "0x") && !strings.HasPrefix(raw, "0o") && !strings.HasPrefix(raw, "0b") {
+			raw = strings.ReplaceAll(raw, "_", "")
+		} else if n, err := strconv.ParseInt(strings.ReplaceAll(raw, "_", ""), 0, 64); err == nil {
+			raw = strconv.FormatInt(n, 10)
+		}
+	default:
+		return nil, p.errorf("invalid value %q", raw)
+	}
+	return &configNode{line: line, scalar: raw}, nil
+}
+
+// str reads a basic, literal or multi-line string.
+func (p *tomlParser) str() (string, error) {
+	quote := p.src[p.pos : p.pos+1]
+	multi := strings.HasPrefix(p.src[p.pos:], quote+quote+quote)
+	if multi {
+		quote = quote + quote + quote
+		p.pos += 3
+		//a newline right after the opening quotes is trimmed
+		if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+			p.pos += 2
+			p.line++
+		} else if !p.eof() && p.peek() == '\n' {
+			p.pos++
+			p.line++
+		}
+	} else {
+		p.pos++
+	}
+	basic := quote[0] == '"'
+	var buf strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], quote) {
+			p.pos += len(quote)
+			return buf.String(), nil
+		}
+		c := p.peek()
+		switch {
+		case c == '\n' && !multi:
+			return "", p.errorf("unterminated string")
+		case c == '\n':
+			p.line++
+		case c == '\\' && basic:
+			if err := p.escape(&buf, multi); err != nil {
+				return "", err
+			}
+			continue
+		}
+		buf.WriteByte(c)
+		p.pos++
+	}
+}
+
+func (p *tomlParser) escape(buf *strings.Builder, multi bool) error {
+	p.pos++
+	if p.eof() {
+		return p.errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'n':
+		buf.WriteByte('\n')
+	case 't':
+		buf.WriteByte('\t')
+	case 'r':
+		buf.WriteByte('\r')
+	case 'b':
+		buf.WriteByte('\b')
+	case 'f':
+		buf.WriteByte('\f')
+	case '"', '\\':
+		buf.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return p.errorf("invalid unicode escape")
+		}
+		r, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("invalid unicode escape")
+		}
+		buf.WriteRune(rune(r))
+		p.pos += size
+	case ' ', '\t', '\r', '\n':
+		//a line-ending backslash trims the following whitespace
+		if !multi {
+			return p.errorf("invalid escape")
+		}
+		p.pos--
+		for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+			if p.peek() == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+// YAML
+
+// yamlLine is a line of a YAML file with comments removed.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+	// raw keeps the line as written, for block scalars.
+	raw string
+}
+
+// parseYAMLConfig reads the block-style YAML a config file needs:
+// nested mappings, sequences of scalars in block or [a, b] form, plain,
+// single- and double-quoted scalars, | and > block scalars and
+// comments. Anchors, tags, flow mappings and multiple documents are not
+// supported.
+func parseYAMLConfig(data []byte, filename string) (*configNode, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		text := strings.TrimRight(yamlStripComment(raw), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, &ConfigError{filename, i + 1, "tabs are not allowed for indentation"}
+		}
+		if trimmed == "---" && len(lines) == 0 || trimmed == "..." {
+			continue
+		}
+		if trimmed == "---" {
+			return nil, &ConfigError{filename, i + 1, "multiple documents are not supported"}
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed, raw: raw})
+	}
+	y := &yamlParser{lines: lines, file: filename}
+	y.skipBlank()
+	if y.pos == len(y.lines) {
+		return newTableNode(1), nil
+	}
+	root, err := y.block(y.lines[y.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	if !root.isTable() {
+		return nil, &ConfigError{filename, root.line, "the top level must be a mapping"}
+	}
+	if y.skipBlank(); y.pos < len(y.lines) {
+		return nil, y.errorf("unexpected indentation")
+	}
+	return root, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	file  string
+}
+
+func (y *yamlParser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if y.pos < len(y.lines) {
+		line = y.lines[y.pos].num
+	}
+	return &ConfigError{y.file, line, fmt.Sprintf(format, args...)}
+}
+
+func (y *yamlParser) skipBlank() {
+	for y.pos < len(y.lines) && y.lines[y.pos].text == "" {
+		y.pos++
+	}
+}
+
+// block reads the mapping or sequence whose lines are at indent.
+func (y *yamlParser) block(indent int) (*configNode, error) {
+	first := y.lines[y.pos]
+	if first.text == "-" || strings.HasPrefix(first.text, "- ") {
+		return y.sequence(indent)
+	}
+	n := newTableNode(first.num)
+	for y.skipBlank(); y.pos < len(y.lines); y.skipBlank() {
+		l := y.lines[y.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, y.errorf("unexpected indentation")
+		}
+		key, rest, ok := yamlSplitKey(l.text)
+		if !ok {
+			return nil, y.errorf("expected key: value, got %q", l.text)
+		}
+		y.pos++
+		var child *configNode
+		var err error
+		switch {
+		case rest == "":
+			y.skipBlank()
+			if y.pos < len(y.lines) && (y.lines[y.pos].indent > indent ||
+				y.lines[y.pos].indent == indent && strings.HasPrefix(y.lines[y.pos].text, "- ")) {
+				child, err = y.block(y.lines[y.pos].indent)
+			} else {
+				child = &configNode{}
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			child, err = y.blockScalar(indent, rest)
+		default:
+			child, err = y.scalarOrFlow(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		child.line = l.num
+		if err := n.put(key, child); err != nil {
+			return nil, &ConfigError{y.file, l.num, err.Error()}
+		}
+	}
+	return n, nil
+}
+
+func (y *yamlParser) sequence(indent int) (*configNode, error) {
+	n := &configNode{line: y.lines[y.pos].num, isList: true}
+	for y.skipBlank(); y.pos < len(y.lines); y.skipBlank() {
+		l := y.lines[y.pos]
+		if l.indent < indent || l.indent == indent && l.text != "-" && !strings.HasPrefix(l.text, "- ") {
+			break
+		}
+		if l.indent > indent {
+			return nil, y.errorf("unexpected indentation")
+		}
+		y.pos++
+		item := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		if _, _, isMap := yamlSplitKey(item); isMap && item[0] != '"' && item[0] != '\'' {
+			return nil, &ConfigError{y.file, l.num, "only lists of plain values are supported"}
+		}
+		child, err := y.scalarOrFlow(item, l.num)
+		if err != nil {
+			return nil, err
+		}
+		n.list = append(n.list, child)
+	}
+	return n, nil
+}
+
+// blockScalar reads a | or > scalar whose lines are indented past
+// indent.
+func (y *yamlParser) blockScalar(indent int, header string) (*configNode, error) {
+	line := y.lines[y.pos-1].num
+	folded, chomp := header[0] == '>', header[1:]
+	var parts []string
+	blockIndent := -1
+	for y.pos < len(y.lines) {
+		//comments are content here, so work from the raw line
+		l := y.lines[y.pos]
+		raw := strings.TrimRight(l.raw, " \t\r")
+		if raw == "" {
+			y.pos++
+			parts = append(parts, "")
+			continue
+		}
+		rawIndent := len(raw) - len(strings.TrimLeft(raw, " "))
+		if rawIndent <= indent {
+			break
+		}
+		y.pos++
+		if blockIndent < 0 {
+			blockIndent = rawIndent
+		}
+		if rawIndent < blockIndent {
+			return nil, &ConfigError{y.file, l.num, "bad indentation in block scalar"}
+		}
+		parts = append(parts, raw[blockIndent:])
+	}
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	sep := "\n"
+	if folded {
+		sep = " "
+	}
+	s := strings.Join(parts, sep)
+	if chomp != "-" && s != "" {
+		s += "\n"
+	}
+	return &configNode{line: line, scalar: s}, nil
+}
+
+func (y *yamlParser) scalarOrFlow(text string, line int) (*configNode, error) {
+	if text == "" {
+		//a bare "-" item is null
+		return &configNode{line: line}, nil
+	}
+	if text[0] == '{' {
+		return nil, &ConfigError{y.file, line, "flow mappings are not supported"}
+	}
+	if text[0] != '[' {
+		s, err := yamlScalar(text)
+		if err != nil {
+			return nil, &ConfigError{y.file, line, err.Error()}
+		}
+		return &configNode{line: line, scalar: s}, nil
+	}
+	if !strings.HasSuffix(text, "]") {
+		return nil, &ConfigError{y.file, line, "unterminated flow sequence"}
+	}
+	n := &configNode{line: line, isList: true}
+	for _, item := range yamlSplitFlow(text[1 : len(text)-1]) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		s, err := yamlScalar(item)
+		if err != nil {
+			return nil, &ConfigError{y.file, line, err.Error()}
+		}
+		n.list = append(n.list, &configNode{line: line, scalar: s})
+	}
+	return n, nil
+}
+
+// yamlScalar decodes a plain or quoted scalar. Plain and double-quoted
+// ones expand ${VAR}, like unquoted and double-quoted INI values.
+func yamlScalar(text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	switch text[0] {
+	case '\'':
+		if len(text) < 2 || text[len(text)-1] != '\'' {
+			return "", errors.New("unterminated quoted value " + text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '"':
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return "", errors.New("invalid quoted value " + text)
+		}
+		return expandEnv(s)
+	}
+	if text == "~" || text == "null" {
+		return "", nil
+	}
+	return expandEnv(text)
+}
+
+// yamlSplitKey splits "key: value" outside quotes.
+func yamlSplitKey(text string) (key string, rest string, ok bool) {
+	if text == "" {
+		return "", "", false
+	}
+	if q := text[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(text[1:], q)
+		if end < 0 {
+			return "", "", false
+		}
+		key, text = text[1:end+1], text[end+2:]
+		if !strings.HasPrefix(text, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(text[1:]), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// yamlSplitFlow splits the items of a flow sequence on commas outside
+// quotes.
+func yamlSplitFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+// yamlStripComment removes a # comment that starts a line or follows a
+// space, outside quotes.
+func yamlStripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" :-[,", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,105 @@
+package goweb
+
+import (
+	"strings"
+	"testing"
+)
+
+// formatTests parse a file and check the value of section.key, or that
+// parsing fails with an error mentioning err.
+var formatTests = []struct {
+	name string
+	src  string
+	key  string
+	want string
+	err  string
+}{
+	// JSON
+	{"app.json", `{"a": 1}`, "a", "1", ""},
+	{"app.json", `{"a": 1e3}`, "a", "1000", ""},
+	{"app.json", `{"a": 1.0}`, "a", "1", ""},
+	{"app.json", `{"a": -2.50}`, "a", "-2.50", ""},
+	{"app.json", `{"a": 1e30}`, "a", "1e30", ""},
+	{"app.json", `{"a": 12345678901234567890}`, "a", "12345678901234567890", ""},
+	{"app.json", `{"db": {"pool": {"max": 5}}}`, "db.pool.max", "5", ""},
+	{"app.json", `{"a": null}`, "a", "", ""},
+	{"app.json", `{"a": true, "b": [1, "x", null]}`, "b", "1, x, ", ""},
+	{"app.json", `{"a": []}`, "a", "", ""},
+	{"app.json", `{}`, "a", "", ""},
+	{"app.json", `{"a": 1, "a": 2}`, "", "", "duplicate key"},
+	{"app.json", `[1]`, "", "", "top level must be an object"},
+	{"app.json", `{"a": 1} {}`, "", "", "unexpected data"},
+	{"app.json", `{"a": ["x,y", "z"]}`, "", "", "contains a comma"},
+	{"app.json", "{\n\"a\": [[1]]}", "", "", "app.json:2"},
+	{"app.json", `{"a": `, "", "", "app.json"},
+
+	// TOML
+	{"app.toml", "a = 1\n", "a", "1", ""},
+	{"app.toml", "", "a", "", ""},
+	{"app.toml", "# only a comment", "a", "", ""},
+	{"app.toml", "[db]\nhost = 'h' # c\n", "db.host", "h", ""},
+	{"app.toml", "[db.pool]\nmax = 5\n", "db.pool.max", "5", ""},
+	{"app.toml", "[db.pool]\nmax = 5\n[db]\nhost = 'h'\n", "db.host", "h", ""},
+	{"app.toml", "a = [\n  1,\n  2,\n]\n", "a", "1, 2", ""},
+	{"app.toml", "a = []\n", "a", "", ""},
+	{"app.toml", "a = \"\"\"\nx\ny\"\"\"\n", "a", "x\ny", ""},
+	{"app.toml", "[db]\nx = { a = 1 }\n", "db.x.a", "1", ""},
+	{"app.toml", "a = 1\na = 2\n", "", "", "app.toml:2"},
+	{"app.toml", "[[t]]\n", "", "", "arrays of tables"},
+	{"app.toml", "a = ['x,y']\n", "", "", "contains a comma"},
+	{"app.toml", "a = \"x\n", "", "", "app.toml:1"},
+	{"app.toml", "a = bogus\n", "", "", "invalid value"},
+	{"app.toml", "[db\n", "", "", "expected ]"},
+	{"app.toml", "[db]\na = 1\n[db]\nb = 2\n", "", "", "app.toml:3: table [db] is already defined on line 1"},
+	{"app.toml", "[db.pool]\n[db]\n[db]\n", "", "", "already defined on line 2"},
+	{"app.toml", "x = { a = 1 }\n[x]\n", "", "", "table [x] is already defined"},
+
+	// YAML
+	{"app.yaml", "a: 1\n", "a", "1", ""},
+	{"app.yaml", "", "a", "", ""},
+	{"app.yaml", "---\na: 1\n...\n", "a", "1", ""},
+	{"app.yaml", "a:\n", "a", "", ""},
+	{"app.yaml", "a: ~\n", "a", "", ""},
+	{"app.yaml", "list:\n  -\n  - a\n", "list", ", a", ""},
+	{"app.yaml", "list:\n- a\n- b\n", "list", "a, b", ""},
+	{"app.yaml", "list: []\n", "list", "", ""},
+	{"app.yaml", "list: [a, 'b c', \"d\"]\n", "list", "a, b c, d", ""},
+	{"app.yaml", "a: 'it''s'\n", "a", "it's", ""},
+	{"app.yaml", "a: x # comment\n", "a", "x", ""},
+	{"app.yaml", "a: |\n  one\n  # two\n", "a", "one\n# two\n", ""},
+	{"app.yaml", "a: >-\n  one\n  two\n", "a", "one two", ""},
+	{"app.yaml", "db:\n  pool:\n    max: 5\n", "db.pool.max", "5", ""},
+	{"app.yaml", "- a\n", "", "", "top level must be a mapping"},
+	{"app.yaml", "a: {b: 1}\n", "", "", "flow mappings"},
+	{"app.yaml", "a:\n  - 'x,y'\n", "", "", "app.yaml:2"},
+	{"app.yaml", "a: x,y\n", "a", "x,y", ""},
+	{"app.yaml", "list:\n  - a: 1\n", "", "", "plain values"},
+	{"app.yaml", "a: 1\n\tb: 2\n", "", "", "tabs"},
+	{"app.yaml", "a: 1\n  b: 2\n", "", "", "app.yaml:2"},
+	{"app.yaml", "a: 1\n---\nb: 2\n", "", "", "multiple documents"},
+	{"app.yaml", "a: 'x\n", "", "", "unterminated"},
+	{"app.yaml", "a: 1\na: 2\n", "", "", "duplicate key"},
+}
+
+func TestConfigFormats(t *testing.T) {
+	for _, tt := range formatTests {
+		cfg, err := parseConfigFile(strings.NewReader(tt.src), tt.name)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %q: got error %v, want %q", tt.name, tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.name, tt.src, err)
+			continue
+		}
+		section, key := cfg, tt.key
+		if i := strings.IndexByte(key, '.'); i >= 0 {
+			section, key = cfg.Section(key[:i]), key[i+1:]
+		}
+		if got := section.GetString(key, ""); got != tt.want {
+			t.Errorf("%s %q: %s = %q, want %q", tt.name, tt.src, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package goweb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configInclude is an include directive waiting to be loaded.
+type configInclude struct {
+	path string
+	line int
+}
+
+// LoadLayeredConfig loads filename and then, if they exist, the layers
+// next to it that override it key by key: app.<env>.conf, where env is
+// the GOWEB_ENV environment variable, and then app.local.conf, which is
+// meant to stay out of version control. The layer names keep filename's
+// extension, so app.yaml is followed by app.production.yaml and
+// app.local.yaml. Each file may include others, and the environment
+// overrides apply last, over every layer.
+func LoadLayeredConfig(filename string) (*Config, error) {
+	cfg, err := loadConfigFile(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range configLayers(filename) {
+		over, err := loadConfigFile(layer, nil)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cfg.merge(over)
+	}
+	cfg.applyEnv(os.Environ())
+	if err := cfg.decryptSecrets(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configLayers returns the optional files layered over filename.
+func configLayers(filename string) []string {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	var layers []string
+	if env := os.Getenv("GOWEB_ENV"); env != "" {
+		layers = append(layers, stem+"."+env+ext)
+	}
+	return append(layers, stem+".local"+ext)
+}
+
+// loadConfigFile parses filename and the files it includes, without
+// the environment overrides. stack holds the including files, to catch
+// cycles.
+func loadConfigFile(filename string, stack []string) (*Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cfg, err := parseConfigFile(file, filename)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.resolveIncludes(stack)
+}
+
+// resolveIncludes loads c's includes in order and returns them merged,
+// with c's own keys on top. It returns c itself if there are none.
+func (c *Config) resolveIncludes(stack []string) (*Config, error) {
+	if c.file != "" {
+		c.loaded = []string{c.file}
+	}
+	if len(c.includes) == 0 {
+		return c, nil
+	}
+	stack = append(stack, filepath.Clean(c.file))
+	base := newConfig(c.file, "")
+	for _, inc := range c.includes {
+		path := filepath.Clean(inc.path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(c.file), path)
+		}
+		for _, f := range stack {
+			if f == path {
+				return nil, &ConfigError{c.file, inc.line, "include cycle: " + strings.Join(append(stack, path), " -> ")}
+			}
+		}
+		sub, err := loadConfigFile(path, stack)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &ConfigError{c.file, inc.line, "include: " + err.Error()}
+		}
+		if err != nil {
+			return nil, err
+		}
+		base.merge(sub)
+	}
+	base.merge(c)
+	return base, nil
+}
+
+// merge sets every key of over in c, replacing what c had, and keeps
+// where each value came from.
+func (c *Config) merge(over *Config) {
+	for key, value := range over.keys {
+		c.setFrom(key, value, over.files[key], over.lines[key])
+	}
+	if over.global != nil {
+		for key, value := range over.global.keys {
+			c.globals().setFrom(key, value, over.global.files[key], over.global.lines[key])
+		}
+	}
+	for _, name := range over.order {
+		from := over.sections[strings.ToLower(name)]
+		section := c.addSection(name)
+		for key, value := range from.keys {
+			section.setFrom(key, value, from.files[key], from.lines[key])
+		}
+	}
+	c.loaded = append(c.loaded, over.loaded...)
+}
+
+// Source reports where the value of key was set: the file and line, or
+// "$NAME" and line 0 for a value taken from the environment variable
+// NAME. file is "" if key is not set.
+func (c *Config) Source(key string) (file string, line int) {
+	return c.files[key], c.lines[key]
+}
+
+// Files returns every file the config was read from, in the order they
+// were applied: included files before the file including them, and
+// layers after the file they override.
+func (c *Config) Files() []string {
+	return append([]string(nil), c.loaded...)
+}
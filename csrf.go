@@ -0,0 +1,198 @@
+package goweb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// CsrfOptions configures the middleware returned by Csrf.
+type CsrfOptions struct {
+	// CookieName is the cookie holding the signed token, default "_csrf".
+	CookieName string
+	// FieldName is the form field checked on unsafe requests, default "_csrf".
+	FieldName string
+	// HeaderName is checked before the form field and is also set on every
+	// response so JSON clients can read the token, default "X-CSRF-Token".
+	HeaderName string
+	// CheckOrigin rejects unsafe requests whose Origin (or Referer) names
+	// a host other than the request host or one of TrustedOrigins.
+	CheckOrigin    bool
+	TrustedOrigins []string
+	// Exempt lists route regexes that skip verification, e.g. webhooks.
+	// One that does not compile is logged and left out, as for routes.
+	Exempt []string
+}
+
+// Csrf returns a middleware implementing double-submit-cookie CSRF
+// protection. The token is kept in a cookie signed with
+// ServerConfig.CookieSecret and must be echoed back in a form field or
+// header on POST, PUT, DELETE and other unsafe methods.
+func Csrf(opts *CsrfOptions) Middleware {
+	o := CsrfOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.CookieName == "" {
+		o.CookieName = "_csrf"
+	}
+	if o.FieldName == "" {
+		o.FieldName = "_csrf"
+	}
+	if o.HeaderName == "" {
+		o.HeaderName = "X-CSRF-Token"
+	}
+	var exempt []*regexp.Regexp
+	var bad []string
+	for _, r := range o.Exempt {
+		cr, err := regexp.Compile(r)
+		if err != nil {
+			bad = append(bad, r)
+			continue
+		}
+		exempt = append(exempt, cr)
+	}
+	//there is no logger until the first request
+	var logBad sync.Once
+
+	return func(ctx *Context, next func()) {
+		logBad.Do(func() {
+			for _, r := range bad {
+				ctx.Server.Logger.Printf("Error in CSRF exempt regex %q\n", r)
+			}
+		})
+		secret := ctx.Server.settings().CookieSecret
+		if secret == "" {
+			ctx.Log("Secret Key for CSRF tokens has not been set. Please assign a cookie secret to web.Config.CookieSecret.")
+			ctx.Abort(500, "Server Error")
+			return
+		}
+
+		token, ok := csrfCookieToken(ctx, o.CookieName, secret)
+		if !ok {
+			token = newCsrfToken()
+			ctx.setCookie(&http.Cookie{
+				Name:     o.CookieName,
+				Value:    token + "." + getCookieSig(secret, []byte(token), "csrf"),
+				Path:     "/",
+				Domain:   ctx.Server.settings().CookieDomain,
+				HttpOnly: true,
+				Secure:   ctx.Scheme() == "https",
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		ctx.csrfToken = token
+		ctx.csrfField = o.FieldName
+		ctx.SetHeader(o.HeaderName, token, true)
+
+		if csrfSafeMethod(ctx.Request.Method) || matchesAny(exempt, ctx.Request.URL.Path) {
+			next()
+			return
+		}
+
+		if o.CheckOrigin && !csrfOriginAllowed(ctx, o.TrustedOrigins) {
+			ctx.Abort(403, "Forbidden: cross-origin request")
+			return
+		}
+
+		sent := ctx.Request.Header.Get(o.HeaderName)
+		if sent == "" {
+			sent = ctx.Request.FormValue(o.FieldName)
+		}
+		if !ok || !hmac.Equal([]byte(sent), []byte(token)) {
+			ctx.Abort(403, "Forbidden: invalid CSRF token")
+			return
+		}
+		next()
+	}
+}
+
+// CsrfToken returns the CSRF token for the current request, or "" when
+// the Csrf middleware is not in use.
+func (ctx *Context) CsrfToken() string {
+	return ctx.csrfToken
+}
+
+// CsrfField returns a hidden form input carrying the CSRF token. Templates
+// rendered with Render can call it as {{csrfField}}, and the token alone
+// as {{csrfToken}}.
+func (ctx *Context) CsrfField() template.HTML {
+	if ctx.csrfToken == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + html.EscapeString(ctx.csrfField) +
+		`" value="` + html.EscapeString(ctx.csrfToken) + `">`)
+}
+
+func newCsrfToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func csrfCookieToken(ctx *Context, name string, secret string) (string, bool) {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(cookie.Value, ".")
+	if i <= 0 {
+		return "", false
+	}
+	token, sig := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(getCookieSig(secret, []byte(token), "csrf")), []byte(sig)) {
+		return "", false
+	}
+	return token, true
+}
+
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func csrfOriginAllowed(ctx *Context, trusted []string) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		origin = ctx.Request.Header.Get("Referer")
+	}
+	if origin == "" {
+		//nothing to check; the token still has to match
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, ctx.Host()) {
+		return true
+	}
+	for _, t := range trusted {
+		if strings.EqualFold(u.Host, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether path fully matches one of the route regexes.
+func matchesAny(routes []*regexp.Regexp, path string) bool {
+	for _, cr := range routes {
+		if match := cr.FindString(path); match != "" && len(match) == len(path) {
+			return true
+		}
+	}
+	return false
+}
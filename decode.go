@@ -0,0 +1,221 @@
+package goweb
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ByteSize is a size in bytes. In a config file it is written as a
+// number with an optional unit: B, K, M, G or T, optionally followed by
+// B or iB, all in powers of 1024. "64MB", "1.5g" and "4096" are valid.
+type ByteSize int64
+
+var byteUnits = map[string]float64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s := strings.ToLower(strings.TrimSpace(string(text)))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	if unit != "b" {
+		unit = strings.TrimSuffix(strings.TrimSuffix(unit, "ib"), "b")
+	}
+	mult, ok := byteUnits[unit]
+	n, err := strconv.ParseFloat(num, 64)
+	if !ok || err != nil || n < 0 {
+		return fmt.Errorf("invalid byte size %q", string(text))
+	}
+	*b = ByteSize(n * mult)
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Decode fills the struct v points to from section, or from the root
+// when section is "". Each exported field is read from the key named by
+// its config tag, or its lower-cased name without one:
+//
+//	type MysqlConfig struct {
+//		DSN     string         `config:"dbconn,required"`
+//		MaxPool int            `config:"maxpoolsize" default:"100"`
+//		Timeout time.Duration  `default:"5s"`
+//		Buffer  goweb.ByteSize `default:"64KB"`
+//		Hosts   []string
+//		Admin   *url.URL
+//	}
+//
+// A tag of "-" skips the field. Keys that are missing or empty take the
+// default tag, or leave the field unchanged without one; with the
+// required option they are an error instead. Strings, bools, integers,
+// floats, durations (a bare number is seconds), ByteSize, URLs, types
+// implementing encoding.TextUnmarshaler, pointers to those and
+// comma-separated slices of them are supported. Decode fills every field
+// it can and returns the first error, a ConfigError naming the key and
+// the line it was set on.
+func (c *Config) Decode(section string, v interface{}) error {
+	src := c
+	if section != "" {
+		src = c.Section(section)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("goweb: Decode needs a pointer to a struct")
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	var firstErr error
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		key, required := configKey(f)
+		if key == "" {
+			continue
+		}
+		raw := src.keys[key]
+		if raw == "" {
+			if required {
+				if firstErr == nil {
+					firstErr = src.configError(key, "required key is missing")
+				}
+				continue
+			}
+			def, ok := f.Tag.Lookup("default")
+			if !ok {
+				continue
+			}
+			raw = def
+		}
+		if err := setConfigField(rv.Field(i), raw); err != nil && firstErr == nil {
+			firstErr = src.configError(key, err.Error())
+		}
+	}
+	return firstErr
+}
+
+// configKey returns the key an exported struct field is decoded from,
+// or "" if it is skipped.
+func configKey(f reflect.StructField) (key string, required bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := strings.Split(f.Tag.Get("config"), ",")
+	key = tag[0]
+	if key == "-" {
+		return "", false
+	}
+	if key == "" {
+		key = strings.ToLower(f.Name)
+	}
+	for _, opt := range tag[1:] {
+		required = required || strings.TrimSpace(opt) == "required"
+	}
+	return key, required
+}
+
+func (c *Config) configError(key string, msg string) error {
+	where := ""
+	if c.name != "" {
+		where = " in [" + c.name + "]"
+	}
+	file, line := c.Source(key)
+	if file == "" {
+		file = c.file
+	}
+	return &ConfigError{file, line, fmt.Sprintf("%s%s: %s", key, where, msg)}
+}
+
+func setConfigField(v reflect.Value, raw string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+	switch v.Type() {
+	case durationType:
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			v.SetInt(n * int64(time.Second))
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case urlType:
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", raw)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := setConfigField(p.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigField(s.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
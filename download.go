@@ -0,0 +1,121 @@
+package goweb
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Attachment sends content as a download named filename. When content is
+// an io.ReadSeeker, e.g. a bytes.Reader over a blob, it is served with
+// ServeContent so Range requests work; conditional requests need an ETag
+// set by the handler, such as ContentEtag(content).
+func (ctx *Context) Attachment(filename string, content io.Reader) {
+	ctx.SetHeader("Content-Disposition", contentDisposition("attachment", filename), true)
+	if rs, ok := content.(io.ReadSeeker); ok {
+		ctx.ServeContent(filename, time.Time{}, rs)
+		return
+	}
+	ctx.setContentTypeFor(filename)
+	ctx.Header().Del("Content-Length")
+	if _, err := io.Copy(ctx.ResponseWriter, content); err != nil {
+		ctx.Log("Error during write: ", err)
+	}
+}
+
+// ServeContent sends content with support for Range, If-Modified-Since
+// and If-None-Match. The Content-Type is derived from name. Unless the
+// handler set an ETag, a weak one is built from modtime and size; with a
+// zero modtime there is none, and a handler that wants one can set
+// ContentEtag(content).
+func (ctx *Context) ServeContent(name string, modtime time.Time, content io.ReadSeeker) {
+	ctx.setContentTypeFor(name)
+	ctx.Header().Del("Content-Length")
+	if ctx.Header().Get("ETag") == "" && !modtime.IsZero() {
+		size, err := content.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = content.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			ctx.Log("Error reading content: ", err)
+			ctx.Abort(500, "Server Error")
+			return
+		}
+		ctx.SetHeader("ETag", fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size), true)
+	}
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, name, modtime, content)
+}
+
+func (ctx *Context) setContentTypeFor(name string) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		ctx.SetHeader("Content-Type", ctype, true)
+	} else {
+		//let http.ServeContent sniff it
+		ctx.Header().Del("Content-Type")
+	}
+}
+
+// ContentEtag returns a strong ETag hashed from all of content, which is
+// left at its start. It reads the whole content, so it is meant for
+// small blobs or for values the caller keeps.
+func ContentEtag(content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)), nil
+}
+
+// contentDisposition builds a Content-Disposition header with an ASCII
+// fallback filename and an RFC 5987 UTF-8 filename* for everything else.
+func contentDisposition(disposition string, filename string) string {
+	filename = filepath.Base(filename)
+	var ascii, encoded strings.Builder
+	needsEncoding := false
+	for i := 0; i < len(filename); i++ {
+		c := filename[i]
+		switch {
+		case c >= 0x80:
+			needsEncoding = true
+			if c >= 0xc0 {
+				//one replacement per UTF-8 sequence
+				ascii.WriteByte('_')
+			}
+		case c < ' ' || c == '"' || c == '\\' || c == 0x7f:
+			needsEncoding = true
+			ascii.WriteByte('_')
+		default:
+			ascii.WriteByte(c)
+		}
+		if isAttrChar(c) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	header := disposition + `; filename="` + ascii.String() + `"`
+	if needsEncoding {
+		header += "; filename*=UTF-8''" + encoded.String()
+	}
+	return header
+}
+
+// isAttrChar reports whether c may appear unescaped in an RFC 5987 value.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
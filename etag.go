@@ -0,0 +1,100 @@
+package goweb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag returns a middleware that hashes the string or []byte returned by
+// route handlers into an ETag and answers a matching If-None-Match with
+// 304 Not Modified. Weak tags suit responses whose bytes may change
+// without their meaning changing, e.g. after compression.
+func ETag(weak bool) Middleware {
+	return func(ctx *Context, next func()) {
+		ctx.autoEtag = true
+		ctx.weakEtag = weak
+		next()
+	}
+}
+
+// writeEtag tags content returned by a handler and reports whether a 304
+// was sent instead of the body. A response whose header already went
+// out, such as a 404 the handler wrote itself, is left alone.
+func (ctx *Context) writeEtag(content []byte) bool {
+	method := ctx.Request.Method
+	if !ctx.autoEtag || method != "GET" && method != "HEAD" || ctx.Header().Get("ETag") != "" {
+		return false
+	}
+	if ctx.writer != nil && ctx.writer.Written() {
+		return false
+	}
+	sum := sha1.Sum(content)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if ctx.weakEtag {
+		etag = "W/" + etag
+	}
+	ctx.SetHeader("ETag", etag, true)
+	if etagMatch(ctx.Request.Header.Get("If-None-Match"), etag) {
+		ctx.NotModified()
+		return true
+	}
+	return false
+}
+
+// CheckModified sets the ETag and Last-Modified validators and, if the
+// request's If-None-Match or If-Modified-Since shows the client is up to
+// date, sends 304 Not Modified and returns true. Handlers can call it
+// before doing expensive work:
+//
+//	if ctx.CheckModified(article.Version, article.Updated) {
+//		return
+//	}
+//
+// Either validator may be left empty or zero.
+func (ctx *Context) CheckModified(etag string, modtime time.Time) bool {
+	if etag != "" && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = `"` + etag + `"`
+	}
+	if etag != "" {
+		ctx.SetHeader("ETag", etag, true)
+	}
+	if !modtime.IsZero() {
+		ctx.SetHeader("Last-Modified", webTime(modtime.UTC()), true)
+	}
+
+	method := ctx.Request.Method
+	if method != "GET" && method != "HEAD" {
+		return false
+	}
+	if inm := ctx.Request.Header.Get("If-None-Match"); inm != "" {
+		//If-None-Match takes precedence over If-Modified-Since
+		if etag != "" && etagMatch(inm, etag) {
+			ctx.NotModified()
+			return true
+		}
+		return false
+	}
+	if ims := ctx.Request.Header.Get("If-Modified-Since"); ims != "" && !modtime.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modtime.Truncate(time.Second).After(t) {
+			ctx.NotModified()
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatch does the weak comparison If-None-Match calls for.
+func etagMatch(header string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
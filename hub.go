@@ -0,0 +1,227 @@
+package goweb
+
+import (
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// DropPolicy decides what happens when a subscriber's buffer is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the message that does not fit.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered message to make room.
+	DropOldest
+	// DropSubscriber unsubscribes the slow subscriber, closing its channel.
+	DropSubscriber
+)
+
+// Message is a published message as received by subscribers.
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// Broker carries messages between processes so every Hub sees every
+// publish. RedisBroker is the provided implementation.
+type Broker interface {
+	Publish(topic string, data []byte) error
+	// Start delivers every message published through the broker, by any
+	// process, to deliver until Close is called.
+	Start(deliver func(topic string, data []byte)) error
+	Close() error
+}
+
+// Hub is an in-process topic-based broadcaster. Handlers publish to it
+// and SSE or WebSocket handlers subscribe to fan messages out to clients.
+type Hub struct {
+	mu     sync.RWMutex
+	topics map[string]map[*Subscription]struct{}
+	broker Broker
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: map[string]map[*Subscription]struct{}{}}
+}
+
+// SetBroker routes publishes through b, for deployments with several
+// processes.
+func (h *Hub) SetBroker(b Broker) error {
+	if err := b.Start(h.deliver); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.broker = b
+	h.mu.Unlock()
+	return nil
+}
+
+// Publish sends data to every subscriber of topic. It never blocks on
+// slow subscribers.
+func (h *Hub) Publish(topic string, data []byte) error {
+	h.mu.RLock()
+	b := h.broker
+	h.mu.RUnlock()
+	if b != nil {
+		return b.Publish(topic, data)
+	}
+	h.deliver(topic, data)
+	return nil
+}
+
+func (h *Hub) deliver(topic string, data []byte) {
+	msg := &Message{Topic: topic, Data: data}
+	var slow []*Subscription
+
+	h.mu.RLock()
+	for sub := range h.topics[topic] {
+		if !sub.offer(msg) {
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		sub.Unsubscribe()
+	}
+}
+
+// Subscribe returns a subscription to topics with room for bufSize
+// pending messages, handled per policy when full.
+func (h *Hub) Subscribe(bufSize int, policy DropPolicy, topics ...string) *Subscription {
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+	sub := &Subscription{
+		hub:    h,
+		topics: topics,
+		policy: policy,
+		c:      make(chan *Message, bufSize),
+	}
+	h.mu.Lock()
+	for _, t := range topics {
+		if h.topics[t] == nil {
+			h.topics[t] = map[*Subscription]struct{}{}
+		}
+		h.topics[t][sub] = struct{}{}
+	}
+	h.mu.Unlock()
+	return sub
+}
+
+// Subscription receives the messages published to its topics.
+type Subscription struct {
+	hub     *Hub
+	topics  []string
+	policy  DropPolicy
+	c       chan *Message
+	dropped uint64
+	closed  bool
+	omu     sync.Mutex
+}
+
+// Messages returns the channel messages arrive on. It is closed when the
+// subscription ends.
+func (s *Subscription) Messages() <-chan *Message {
+	return s.c
+}
+
+// Dropped returns how many messages were discarded because the buffer
+// was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe ends the subscription. It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, t := range s.topics {
+		delete(h.topics[t], s)
+		if len(h.topics[t]) == 0 {
+			delete(h.topics, t)
+		}
+	}
+	close(s.c)
+}
+
+// offer queues msg without blocking, and reports false if the subscriber
+// should be dropped. Called with the hub's read lock held.
+func (s *Subscription) offer(msg *Message) bool {
+	select {
+	case s.c <- msg:
+		return true
+	default:
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	switch s.policy {
+	case DropSubscriber:
+		return false
+	case DropOldest:
+		//serialise publishers so the freed slot goes to this message
+		s.omu.Lock()
+		defer s.omu.Unlock()
+		select {
+		case <-s.c:
+		default:
+		}
+		select {
+		case s.c <- msg:
+		default:
+		}
+	}
+	return true
+}
+
+// Subscribe subscribes the request to topics on the server's hub, with a
+// 16 message buffer that drops the oldest message when full. The
+// subscription ends when the request does.
+func (ctx *Context) Subscribe(topics ...string) *Subscription {
+	sub := ctx.Server.Hub.Subscribe(16, DropOldest, topics...)
+	ctx.subs = append(ctx.subs, sub)
+	return sub
+}
+
+// Stream sends each message from sub as an event named after its topic,
+// until the client goes away or the subscription ends.
+func (es *EventStream) Stream(sub *Subscription) error {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-es.Done():
+			return es.ctx.Request.Context().Err()
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return nil
+			}
+			if err := es.Send(msg.Topic, string(msg.Data)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Stream writes each message from sub to the connection, as a text
+// message if it is valid UTF-8 and a binary one otherwise, until the
+// subscription ends or a write fails. Run it in its own goroutine while
+// the handler reads.
+func (c *WebSocketConn) Stream(sub *Subscription) error {
+	defer sub.Unsubscribe()
+	for msg := range sub.Messages() {
+		messageType := BinaryMessage
+		if utf8.Valid(msg.Data) {
+			messageType = TextMessage
+		}
+		if err := c.WriteMessage(messageType, msg.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,92 +1,203 @@
 package goweb
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	_ "github.com/go-sql-driver/mysql"
+	"log"
+	"os"
 	"reflect"
+	"sync"
 )
 
 var connectionPool chan *MysqlHelper = make(chan *MysqlHelper, 100)
-var connectionLimit chan struct{}
 var ConnectionLimitError = errors.New("Connection limit reached")
+
+// poolMu guards the pool settings, which a config reload may change
+// while queries run.
+var poolMu sync.Mutex
+var connectionLimit chan struct{}
 var dbconn string
 var minpoolsize int
 var maxpoolsize int
 var isblockonlimit bool
 
 type MysqlHelper struct {
-	db *sql.DB
+	db     *sql.DB
+	logger *log.Logger
+	// logArgs adds query arguments, which may hold passwords or personal
+	// data, to the query log.
+	logArgs   bool
+	ctx       context.Context
+	requestId string
+	// dsn is the dbconn the pooled connection was opened with, and limit
+	// the connectionLimit it holds a slot in.
+	dsn   string
+	limit chan struct{}
+}
+
+// NewMysqlHelperFromSection creates a helper from a config section, e.g.
+// config.Section("Mysql").
+func NewMysqlHelperFromSection(section *Config) *MysqlHelper {
+	return NewMysqlHelper(section.GetMap())
 }
 
 func NewMysqlHelper(cfg map[string]string) *MysqlHelper {
-	mysqlhelper := &MysqlHelper{
-		nil,
+	mysqlhelper := &MysqlHelper{}
+	if ToBool(cfg["logqueries"], false) {
+		mysqlhelper.logger = log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	}
+	mysqlhelper.logArgs = ToBool(cfg["logqueryargs"], false)
+	configureMysqlPool(cfg)
+	return mysqlhelper
+}
+
+// ConfigureMysqlPool applies the dbconn, minpoolsize, maxpoolsize and
+// isblockonlimit keys of section to the connection pool, without
+// dropping queries in flight. Subscribe it to a ConfigWatcher to pick up
+// changes on reload:
+//
+//	w.Subscribe("Mysql", goweb.ConfigureMysqlPool)
+func ConfigureMysqlPool(section *Config) {
+	configureMysqlPool(section.GetMap())
+}
+
+func configureMysqlPool(cfg map[string]string) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
 	dbconn = cfg["dbconn"]
 	minpoolsize = ToInt(cfg["minpoolsize"], 10)
-	maxpoolsize = ToInt(cfg["maxpoolsize"], 0)
+	limit := ToInt(cfg["maxpoolsize"], 0)
 	isblockonlimit = ToBool(cfg["isblockonlimit"], false)
-	if maxpoolsize > 0 {
-		connectionLimit = make(chan struct{}, maxpoolsize)
-	} else {
-		connectionLimit = nil
+	if limit != maxpoolsize || (limit > 0) != (connectionLimit != nil) {
+		//connections out now give their slot back to the old channel
+		maxpoolsize = limit
+		if maxpoolsize > 0 {
+			connectionLimit = make(chan struct{}, maxpoolsize)
+		} else {
+			connectionLimit = nil
+		}
 	}
-	return mysqlhelper
+}
+
+// SetLogger sets the logger used for query logs; nil disables them.
+// Only the query text is logged unless the logqueryargs key is set.
+func (m *MysqlHelper) SetLogger(logger *log.Logger) {
+	m.logger = logger
+}
+
+// WithContext returns a helper whose queries run under c, so they stop
+// when the request is cancelled or times out, and whose query logs
+// carry the request ID stored in c, e.g. m.WithContext(ctx.Context()).
+func (m *MysqlHelper) WithContext(c context.Context) *MysqlHelper {
+	return &MysqlHelper{db: m.db, logger: m.logger, logArgs: m.logArgs, ctx: c, requestId: RequestIDFromContext(c)}
+}
+
+func (m *MysqlHelper) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+func (m *MysqlHelper) logQuery(query string, args []interface{}) {
+	if m.logger == nil {
+		return
+	}
+	v := []interface{}{query}
+	if m.logArgs {
+		v = append(v, args)
+	}
+	if m.requestId != "" {
+		v = append([]interface{}{"[" + m.requestId + "]"}, v...)
+	}
+	m.logger.Println(v...)
 }
 
 func (m *MysqlHelper) getConn() (*MysqlHelper, error) {
-	if connectionLimit != nil {
-		if isblockonlimit {
-			connectionLimit <- struct{}{}
+	poolMu.Lock()
+	limit, block, dsn := connectionLimit, isblockonlimit, dbconn
+	poolMu.Unlock()
+
+	if limit != nil {
+		if block {
+			select {
+			case limit <- struct{}{}:
+			case <-m.context().Done():
+				return nil, m.context().Err()
+			}
 		} else {
 			select {
-			case connectionLimit <- struct{}{}:
+			case limit <- struct{}{}:
 			default:
 				return nil, ConnectionLimitError
 			}
 		}
 	}
-	select {
-	case m := <-connectionPool:
-		return m, nil
-	default:
+	if conn := idleConn(dsn); conn != nil {
+		conn.limit = limit
+		return conn, nil
 	}
-	db, err := sql.Open("mysql", dbconn)
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
+		if limit != nil {
+			<-limit
+		}
 		return nil, err
 	}
-	m = new(MysqlHelper)
-	m.db = db
-	return m, nil
+	return &MysqlHelper{db: db, dsn: dsn, limit: limit}, nil
+}
+
+// idleConn takes a pooled connection to dsn, closing any opened before
+// dbconn changed.
+func idleConn(dsn string) *MysqlHelper {
+	for {
+		select {
+		case conn := <-connectionPool:
+			if conn.dsn == dsn {
+				return conn
+			}
+			conn.db.Close()
+		default:
+			return nil
+		}
+	}
 }
 
 func (m *MysqlHelper) close() error {
-	if connectionLimit != nil {
-		<-connectionLimit
+	if m.limit != nil {
+		<-m.limit
+		m.limit = nil
 	}
-	select {
-	case connectionPool <- m:
-		return nil
-	default:
+	poolMu.Lock()
+	keep := m.dsn == dbconn && len(connectionPool) < minpoolsize
+	poolMu.Unlock()
+	if keep {
+		select {
+		case connectionPool <- m:
+			return nil
+		default:
+		}
 	}
 	return m.db.Close()
 }
 
 func (m *MysqlHelper) Insert(query string, args ...interface{}) (int64, error) {
+	m.logQuery(query, args)
 	conn, err := m.getConn()
 	if err != nil {
 		return -1, err
 	}
-	defer m.close()
+	defer conn.close()
 
-	stmt, err := conn.db.Prepare(query)
+	stmt, err := conn.db.PrepareContext(m.context(), query)
 	if err != nil {
 		return -1, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(args...)
+	res, err := stmt.ExecContext(m.context(), args...)
 	if err != nil {
 		return -1, err
 	}
@@ -94,19 +205,20 @@ func (m *MysqlHelper) Insert(query string, args ...interface{}) (int64, error) {
 }
 
 func (m *MysqlHelper) Update(query string, args ...interface{}) (int64, error) {
+	m.logQuery(query, args)
 	conn, err := m.getConn()
 	if err != nil {
 		return -1, err
 	}
-	defer m.close()
+	defer conn.close()
 
-	stmt, err := conn.db.Prepare(query)
+	stmt, err := conn.db.PrepareContext(m.context(), query)
 	if err != nil {
 		return -1, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(args...)
+	res, err := stmt.ExecContext(m.context(), args...)
 	if err != nil {
 		return -1, err
 	}
@@ -114,19 +226,20 @@ func (m *MysqlHelper) Update(query string, args ...interface{}) (int64, error) {
 }
 
 func (m *MysqlHelper) QueryForMap(query string, args ...interface{}) (map[string]interface{}, error) {
+	m.logQuery(query, args)
 	conn, err := m.getConn()
 	if err != nil {
 		return nil, err
 	}
-	defer m.close()
+	defer conn.close()
 
-	stmt, err := conn.db.Prepare(query)
+	stmt, err := conn.db.PrepareContext(m.context(), query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(m.context(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -166,19 +279,20 @@ func (m *MysqlHelper) QueryForMap(query string, args ...interface{}) (map[string
 }
 
 func (m *MysqlHelper) QueryForMapSlice(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	m.logQuery(query, args)
 	conn, err := m.getConn()
 	if err != nil {
 		return nil, err
 	}
-	defer m.close()
+	defer conn.close()
 
-	stmt, err := conn.db.Prepare(query)
+	stmt, err := conn.db.PrepareContext(m.context(), query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(m.context(), args...)
 	if err != nil {
 		return nil, err
 	}
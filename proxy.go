@@ -0,0 +1,214 @@
+package goweb
+
+import (
+	"net"
+	"strings"
+)
+
+// SetTrustedProxies sets the proxies whose forwarding headers are
+// honoured by ClientIP, Scheme and Host. Each entry is a CIDR such as
+// "10.0.0.0/8" or a single IP address.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	nets, err := parseTrustedProxies(proxies)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := *s.Config
+	config.TrustedProxies = proxies
+	s.Config = &config
+	s.trustedNets = nets
+	return nil
+}
+
+// parseTrustedProxies parses IPs and CIDR ranges; a bare IP is a range
+// of one address.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, n := range s.trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// fromTrustedProxy reports whether the request came directly from a
+// trusted proxy, i.e. whether its forwarding headers can be believed.
+func (ctx *Context) fromTrustedProxy() bool {
+	return ctx.Server.isTrustedProxy(net.ParseIP(stripPort(ctx.Request.RemoteAddr)))
+}
+
+// ClientIP returns the address of the client, looking through trusted
+// proxies via the Forwarded, X-Forwarded-For and X-Real-IP headers.
+func (ctx *Context) ClientIP() string {
+	remote := stripPort(ctx.Request.RemoteAddr)
+	if !ctx.fromTrustedProxy() {
+		return remote
+	}
+
+	var hops []string
+	for _, elem := range forwardedElements(ctx.Request.Header.Values("Forwarded")) {
+		if v, ok := elem["for"]; ok {
+			hops = append(hops, stripPort(v))
+		}
+	}
+	if len(hops) == 0 {
+		hops = ctx.xForwardedFor()
+	}
+	if len(hops) == 0 {
+		if ip := strings.TrimSpace(ctx.Request.Header.Get("X-Real-IP")); ip != "" {
+			return stripPort(ip)
+		}
+		return remote
+	}
+
+	//walk back from the nearest hop, skipping our own proxies
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		if i == 0 || !ctx.Server.isTrustedProxy(ip) {
+			return hops[i]
+		}
+	}
+	return remote
+}
+
+// Scheme returns "https" or "http" as seen by the client, honouring
+// Forwarded proto= and X-Forwarded-Proto from trusted proxies.
+func (ctx *Context) Scheme() string {
+	if ctx.fromTrustedProxy() {
+		if v, ok := ctx.forwardedParam("proto", "X-Forwarded-Proto"); ok {
+			return strings.ToLower(v)
+		}
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host the client asked for, honouring Forwarded host=
+// and X-Forwarded-Host from trusted proxies.
+func (ctx *Context) Host() string {
+	if ctx.fromTrustedProxy() {
+		if v, ok := ctx.forwardedParam("host", "X-Forwarded-Host"); ok {
+			return v
+		}
+	}
+	return ctx.Request.Host
+}
+
+// forwardedParam returns what the outermost trusted proxy recorded for
+// param, from its Forwarded element or else from header. Values before
+// it came from the client or untrusted hops and are ignored. When header
+// does not line up with X-Forwarded-For, its last value, added by the
+// proxy in front of us, is used.
+func (ctx *Context) forwardedParam(param string, header string) (string, bool) {
+	if elems := forwardedElements(ctx.Request.Header.Values("Forwarded")); len(elems) > 0 {
+		hops := make([]string, len(elems))
+		for i, elem := range elems {
+			hops[i] = stripPort(elem["for"])
+		}
+		if v, ok := elems[ctx.Server.outermostTrusted(hops)][param]; ok {
+			return v, true
+		}
+	}
+	var values []string
+	for _, v := range ctx.Request.Header.Values(header) {
+		for _, value := range strings.Split(v, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return "", false
+	}
+	if hops := ctx.xForwardedFor(); len(hops) == len(values) {
+		return values[ctx.Server.outermostTrusted(hops)], true
+	}
+	return values[len(values)-1], true
+}
+
+// outermostTrusted returns the index of the hop added by the outermost
+// trusted proxy: walking back from the nearest hop, the first whose
+// address is not one of our proxies.
+func (s *Server) outermostTrusted(hops []string) int {
+	for i := len(hops) - 1; i > 0; i-- {
+		if ip := net.ParseIP(hops[i]); ip == nil || !s.isTrustedProxy(ip) {
+			return i
+		}
+	}
+	return 0
+}
+
+func (ctx *Context) xForwardedFor() []string {
+	var hops []string
+	for _, v := range ctx.Request.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// forwardedElements parses RFC 7239 Forwarded header values into one map
+// of lower-cased parameters per hop.
+func forwardedElements(values []string) []map[string]string {
+	var elems []map[string]string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			elem := map[string]string{}
+			for _, pair := range strings.Split(e, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				elem[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+			}
+			if len(elem) > 0 {
+				elems = append(elems, elem)
+			}
+		}
+	}
+	return elems
+}
+
+// stripPort removes a port and IPv6 brackets from an address.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
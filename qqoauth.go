@@ -21,6 +21,12 @@ func NewQqOauth(cfg map[string]string) *QqOauth {
 	return oauth
 }
 
+// NewQqOauthFromSection creates a QqOauth from a config section, e.g.
+// config.Section("QqOauth").
+func NewQqOauthFromSection(section *Config) *QqOauth {
+	return NewQqOauth(section.GetMap())
+}
+
 func (q *QqOauth) GetAuthorizeUrl() string {
 	return q.authorizeurl + "?response_type=code&client_id=" + q.appid + "&routes.go"
 }
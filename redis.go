@@ -0,0 +1,303 @@
+package goweb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisBroker is a Broker that fans hub messages out through Redis
+// PUBLISH/PSUBSCRIBE, so every process sharing the Redis server sees
+// every message. Topics are namespaced with a "goweb:" prefix.
+type RedisBroker struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+
+	// pubMu serializes publishes on pub; mu guards the connections and
+	// is never held across network I/O, so Close cannot get stuck.
+	pubMu   sync.Mutex
+	mu      sync.Mutex
+	pub     net.Conn
+	pubr    *bufio.Reader
+	sub     net.Conn
+	closing chan struct{}
+}
+
+// redisTimeout bounds dialing and each command round trip.
+const redisTimeout = 5 * time.Second
+
+var errBrokerClosed = errors.New("redis: broker is closed")
+
+// NewRedisBroker creates a broker from the [Redis] config section. The
+// redisconn key is either host:port or redis://[:password@]host:port[/db].
+func NewRedisBroker(cfg map[string]string) (*RedisBroker, error) {
+	conn := cfg["redisconn"]
+	if conn == "" {
+		return nil, errors.New("redisconn is not set")
+	}
+	b := &RedisBroker{
+		addr:    conn,
+		prefix:  ToString(cfg["redisprefix"], "goweb:"),
+		closing: make(chan struct{}),
+	}
+	if strings.HasPrefix(conn, "redis://") {
+		u, err := url.Parse(conn)
+		if err != nil {
+			return nil, err
+		}
+		b.addr = u.Host
+		if u.User != nil {
+			b.password, _ = u.User.Password()
+		}
+		b.db = ToInt(strings.TrimPrefix(u.Path, "/"), 0)
+	}
+	return b, nil
+}
+
+// NewRedisBrokerFromSection creates a broker from config.Section("Redis").
+func NewRedisBrokerFromSection(section *Config) (*RedisBroker, error) {
+	return NewRedisBroker(section.GetMap())
+}
+
+func (b *RedisBroker) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, redisTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	if b.password != "" {
+		if _, err := redisCommand(conn, r, "AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	if b.db != 0 {
+		if _, err := redisCommand(conn, r, "SELECT", strconv.Itoa(b.db)); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, r, nil
+}
+
+func (b *RedisBroker) Publish(topic string, data []byte) error {
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	//retry once on a fresh connection if the old one went away
+	for attempt := 0; ; attempt++ {
+		conn, r, err := b.pubConn()
+		if err != nil {
+			return err
+		}
+		_, err = redisCommand(conn, r, "PUBLISH", b.prefix+topic, string(data))
+		if err == nil {
+			return nil
+		}
+		b.mu.Lock()
+		if b.pub == conn {
+			b.pub = nil
+		}
+		b.mu.Unlock()
+		conn.Close()
+		if _, ok := err.(redisError); ok || attempt > 0 {
+			return err
+		}
+	}
+}
+
+// pubConn returns the publishing connection, dialing it if needed. It is
+// called with pubMu held.
+func (b *RedisBroker) pubConn() (net.Conn, *bufio.Reader, error) {
+	b.mu.Lock()
+	conn, r := b.pub, b.pubr
+	b.mu.Unlock()
+	if conn != nil {
+		return conn, r, nil
+	}
+	select {
+	case <-b.closing:
+		return nil, nil, errBrokerClosed
+	default:
+	}
+	conn, r, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closing:
+		conn.Close()
+		return nil, nil, errBrokerClosed
+	default:
+	}
+	b.pub, b.pubr = conn, r
+	return conn, r, nil
+}
+
+// Start subscribes to every topic and reconnects until Close is called.
+func (b *RedisBroker) Start(deliver func(topic string, data []byte)) error {
+	conn, r, err := b.subscribe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			b.receive(conn, r, deliver)
+			//the connection dropped, back off and resubscribe
+			for {
+				select {
+				case <-b.closing:
+					return
+				case <-time.After(time.Second):
+				}
+				if conn, r, err = b.subscribe(); err == nil {
+					break
+				}
+			}
+			select {
+			case <-b.closing:
+				conn.Close()
+				return
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) subscribe() (net.Conn, *bufio.Reader, error) {
+	conn, r, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := redisCommand(conn, r, "PSUBSCRIBE", b.prefix+"*"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	//messages may be far apart; wait for them indefinitely
+	conn.SetDeadline(time.Time{})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closing:
+		conn.Close()
+		return nil, nil, errBrokerClosed
+	default:
+	}
+	b.sub = conn
+	return conn, r, nil
+}
+
+func (b *RedisBroker) receive(conn net.Conn, r *bufio.Reader, deliver func(topic string, data []byte)) {
+	defer conn.Close()
+	for {
+		reply, err := redisRead(r)
+		if err != nil {
+			return
+		}
+		//["pmessage", pattern, channel, data]
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 4 {
+			continue
+		}
+		kind, _ := parts[0].(string)
+		channel, _ := parts[2].(string)
+		data, _ := parts[3].(string)
+		if kind == "pmessage" && strings.HasPrefix(channel, b.prefix) {
+			deliver(strings.TrimPrefix(channel, b.prefix), []byte(data))
+		}
+	}
+}
+
+func (b *RedisBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-b.closing:
+		return nil
+	default:
+	}
+	close(b.closing)
+	if b.pub != nil {
+		b.pub.Close()
+		b.pub = nil
+	}
+	if b.sub != nil {
+		b.sub.Close()
+	}
+	return nil
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisCommand sends a command in RESP and reads one reply, giving up
+// after redisTimeout.
+func redisCommand(conn net.Conn, r *bufio.Reader, args ...string) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := conn.Write([]byte(buf.String())); err != nil {
+		return nil, err
+	}
+	return redisRead(r)
+}
+
+// redisRead reads one RESP reply: strings and bulk strings become string,
+// integers int64, arrays []interface{} and nil bulk strings nil.
+func redisRead(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: bad reply line")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = redisRead(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.New("redis: unknown reply type")
+}
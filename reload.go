@@ -0,0 +1,325 @@
+package goweb
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ConfigWatcher holds the current version of a config file, loaded with
+// its includes and layers as by LoadLayeredConfig, and reloads it on
+// SIGHUP or when any of those files changes. A new version is parsed and
+// validated before it is swapped in, so a broken edit leaves the running
+// config in place, and subscribers are then told about the sections
+// that changed.
+type ConfigWatcher struct {
+	filename string
+
+	mu         sync.RWMutex
+	config     *Config
+	stamps     map[string]fileStamp
+	subs       []configSubscriber
+	validators []func(*Config) error
+
+	reloadMu sync.Mutex
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// fileStamp is what the watcher compares to notice a file change; a
+// file that does not exist has the zero stamp.
+type fileStamp struct {
+	modtime time.Time
+	size    int64
+}
+
+type configSubscriber struct {
+	component string
+	fn        func(section *Config)
+}
+
+// NewConfigWatcher loads filename and reloads it whenever the process
+// gets SIGHUP and, if interval is positive, whenever the modification
+// time or size of one of its files changes, checked every interval. An
+// optional layer appearing or going away counts as a change.
+func NewConfigWatcher(filename string, interval time.Duration) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{filename: filename, stop: make(chan struct{})}
+	config, stamps, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	w.config, w.stamps = config, stamps
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+	}
+	go w.watch(hup, ticker)
+	return w, nil
+}
+
+func (w *ConfigWatcher) watch(hup chan os.Signal, ticker *time.Ticker) {
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if ticker != nil {
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-hup:
+		case <-tick:
+			if !w.fileChanged() {
+				continue
+			}
+		}
+		if err := w.Reload(); err != nil {
+			log.Println("goweb: config not reloaded:", err)
+		}
+	}
+}
+
+// load reads the config and stamps the files it came from, stamping
+// them first so that an edit made while loading is seen next time.
+func (w *ConfigWatcher) load() (*Config, map[string]fileStamp, error) {
+	w.mu.RLock()
+	var files []string
+	if w.config != nil {
+		files = w.config.Files()
+	}
+	w.mu.RUnlock()
+	stamps := map[string]fileStamp{}
+	for _, file := range append(files, configLayers(w.filename)...) {
+		stamps[file] = stampFile(file)
+	}
+	config, err := LoadLayeredConfig(w.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range config.Files() {
+		if _, ok := stamps[file]; !ok {
+			stamps[file] = stampFile(file)
+		}
+	}
+	return config, stamps, nil
+}
+
+func stampFile(file string) fileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info.ModTime(), info.Size()}
+}
+
+func (w *ConfigWatcher) fileChanged() bool {
+	w.mu.RLock()
+	old := w.stamps
+	w.mu.RUnlock()
+	for file, stamp := range old {
+		now := stampFile(file)
+		if !now.modtime.Equal(stamp.modtime) || now.size != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns the current config.
+func (w *ConfigWatcher) Config() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.config
+}
+
+// Subscribe calls fn with the new contents of the component section
+// whenever a reload changes it. The component "" stands for the whole
+// file, as seen by the root Config.
+func (w *ConfigWatcher) Subscribe(component string, fn func(section *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, configSubscriber{component, fn})
+}
+
+// Validate adds a check a new config must pass before it replaces the
+// current one.
+func (w *ConfigWatcher) Validate(fn func(*Config) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.validators = append(w.validators, fn)
+}
+
+// Reload reads the files again and, if it parses and validates, makes it
+// current and notifies the subscribers whose sections changed.
+func (w *ConfigWatcher) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	config, stamps, err := w.load()
+	if err != nil {
+		return err
+	}
+	w.mu.RLock()
+	validators := w.validators
+	w.mu.RUnlock()
+	for _, validate := range validators {
+		if err := validate(config); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	old := w.config
+	w.config, w.stamps = config, stamps
+	subs := w.subs
+	w.mu.Unlock()
+
+	for _, sub := range subs {
+		before, after := old, config
+		if sub.component != "" {
+			before, after = old.Section(sub.component), config.Section(sub.component)
+		}
+		if !reflect.DeepEqual(before.keys, after.keys) {
+			sub.notify(after)
+		}
+	}
+	return nil
+}
+
+// notify calls the subscriber, so that one that panics neither kills
+// the watcher nor keeps the others from hearing about the reload.
+func (sub configSubscriber) notify(section *Config) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("goweb: config subscriber for %q crashed: %v", sub.component, err)
+		}
+	}()
+	sub.fn(section)
+}
+
+// Close stops watching the file.
+func (w *ConfigWatcher) Close() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// Watch keeps the server in step with w: cookie settings, RecoverPanic,
+// TrustRequestId, trusted proxies, DevMode, RequestTimeout, the
+// template directory and static settings and mounts are updated on
+// every reload. Only keys whose value changed are applied, so settings
+// made in code are kept. A new file whose server settings do not decode,
+// or whose trustedproxies do not parse, is rejected as a whole. The
+// compress and etag middleware are set up once and need a restart.
+func (s *Server) Watch(w *ConfigWatcher) {
+	w.Validate(func(config *Config) error {
+		keys := config.serverKeys()
+		sc := &ServerConfig{}
+		if err := keys.Decode("", sc); err != nil {
+			return err
+		}
+		if _, err := parseTrustedProxies(sc.TrustedProxies); err != nil {
+			return keys.configError("trustedproxies", err.Error())
+		}
+		return nil
+	})
+	s.reloadConfig(w.Config())
+	w.Subscribe("", s.reloadConfig)
+}
+
+var staticKeys = []string{
+	"static", "staticmanifest", "staticcachecontrol", "staticprecompressed",
+	"staticallowdotfiles", "staticfollowsymlinks", "staticlisting", "staticdenyext",
+	"staticfallback", "staticfallbackexclude", "staticcache", "staticcachemaxfile",
+}
+
+// staticSectionsChanged reports whether any [static:<prefix>] section
+// differs between old and config.
+func staticSectionsChanged(old *Config, config *Config) bool {
+	for _, c := range []*Config{old, config} {
+		for _, name := range c.Sections() {
+			if _, ok := staticSection(name); ok && !reflect.DeepEqual(old.Section(name).keys, config.Section(name).keys) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reloadConfig applies the keys that differ between the server's config
+// and config, then swaps in new ServerConfig and static state. A config
+// whose server settings do not decode is logged and not applied.
+func (s *Server) reloadConfig(config *Config) {
+	keys := config.serverKeys()
+	next := &ServerConfig{}
+	if err := keys.Decode("", next); err != nil {
+		s.Logger.Println("Error in config, not applied:", err)
+		return
+	}
+
+	s.mu.RLock()
+	old := s.conf
+	sc := *s.Config
+	s.mu.RUnlock()
+	if old == nil {
+		old = newConfig("", "")
+	}
+	oldKeys := old.serverKeys()
+	changed := func(names ...string) bool {
+		for _, key := range names {
+			if oldKeys.keys[key] != keys.keys[key] {
+				return true
+			}
+		}
+		return false
+	}
+
+	cur, nv := reflect.ValueOf(&sc).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < cur.NumField(); i++ {
+		if key, _ := configKey(cur.Type().Field(i)); key != "" && key != "trustedproxies" && changed(key) {
+			cur.Field(i).Set(nv.Field(i))
+		}
+	}
+	if changed("templatedir") && sc.TemplateDir != "" {
+		s.loadTemplateDir(sc.TemplateDir)
+	}
+
+	staticChanged := changed(staticKeys...) || staticSectionsChanged(old, config)
+	var opts StaticOptions
+	var mounts []*staticMount
+	if staticChanged {
+		s.mu.RLock()
+		opts = staticOptionsFromConfig(keys, s.staticOptions.Cache)
+		s.mu.RUnlock()
+		mountOpts := opts
+		mountOpts.Manifest = keys.GetString("staticmanifest", "")
+		mounts = s.staticMountsFromConfig(config, mountOpts)
+	}
+
+	s.mu.Lock()
+	s.conf = config
+	s.Config = &sc
+	if staticChanged {
+		s.staticOptions = opts
+		s.roots = nil
+		for _, m := range s.statics {
+			if !m.fromConfig {
+				mounts = append(mounts, m)
+			}
+		}
+		s.statics = sortMounts(mounts)
+	}
+	s.mu.Unlock()
+
+	if changed("trustedproxies") {
+		if err := s.SetTrustedProxies(next.TrustedProxies); err != nil {
+			s.Logger.Println("Error in trustedproxies:", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package goweb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+type requestIdKey struct{}
+
+// RequestID returns the ID of the current request. It is taken from an
+// X-Request-ID header sent by a trusted proxy, or generated, and is
+// echoed in the response.
+func (ctx *Context) RequestID() string {
+	return ctx.requestId
+}
+
+// Log writes a line to the server logger prefixed with the request ID.
+func (ctx *Context) Log(v ...interface{}) {
+	ctx.Server.Logger.Print("[" + ctx.requestId + "] " + fmt.Sprintln(v...))
+}
+
+// RequestIDFromContext returns the request ID stored in c, so code that
+// only receives ctx.Request.Context() can tag its own logs.
+func RequestIDFromContext(c context.Context) string {
+	id, _ := c.Value(requestIdKey{}).(string)
+	return id
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestId rejects incoming IDs that are too long or would garble
+// log lines and headers.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// assignRequestId picks the ID for ctx and stores it in the request
+// context and the response headers.
+func (s *Server) assignRequestId(ctx *Context) {
+	id := ctx.Request.Header.Get(requestIdHeader)
+	trusted := s.settings().TrustRequestId || ctx.fromTrustedProxy()
+	if !trusted || !validRequestId(id) {
+		id = newRequestId()
+	}
+	ctx.requestId = id
+	ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), requestIdKey{}, id))
+	ctx.SetHeader(requestIdHeader, id, true)
+}
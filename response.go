@@ -0,0 +1,109 @@
+package goweb
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ResponseWriter is the writer every request starts with. It records the
+// status and body size sent to the client and supports flushing and
+// hijacking on every transport that allows it.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	// Status returns the status code sent, or 0 if nothing was sent yet.
+	Status() int
+	// Size returns the number of body bytes written.
+	Size() int
+	// Written reports whether the header has been sent.
+	Written() bool
+}
+
+var ErrHijackNotSupported = errors.New("goweb: connection does not support hijacking")
+
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int
+	hijacked bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status != 0 || w.hijacked {
+		return
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrHijackNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w *responseWriter) Status() int   { return w.status }
+func (w *responseWriter) Size() int     { return w.size }
+func (w *responseWriter) Written() bool { return w.status != 0 }
+
+// Unwrap lets http.ResponseController reach the transport's writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Writer returns the request's ResponseWriter. Middleware may wrap
+// ctx.ResponseWriter, e.g. to compress it; Writer always reports what
+// reached the client.
+func (ctx *Context) Writer() ResponseWriter {
+	return ctx.writer
+}
+
+// Flush sends any buffered response data to the client.
+func (ctx *Context) Flush() {
+	if f, ok := ctx.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, e.g. for protocol upgrades. The
+// caller is responsible for closing it.
+func (ctx *Context) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := ctx.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, ErrHijackNotSupported
+}
@@ -3,6 +3,7 @@ package goweb
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,19 +12,28 @@ import (
 	"net/http/cgi"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type scgiBody struct {
 	reader io.Reader
 	conn   io.ReadWriteCloser
 	closed bool
+	// done is called once the body has been read to the end.
+	done func()
 }
 
 func (b *scgiBody) Read(p []byte) (n int, err error) {
 	if b.closed {
 		return 0, errors.New("SCGI read after close")
 	}
-	return b.reader.Read(p)
+	n, err = b.reader.Read(p)
+	if err == io.EOF && b.done != nil {
+		b.done()
+		b.done = nil
+	}
+	return n, err
 }
 
 func (b *scgiBody) Close() error {
@@ -36,8 +46,49 @@ type scgiConn struct {
 	req          *http.Request
 	headers      http.Header
 	wroteHeaders bool
+	cancel       context.CancelFunc
+	reader       *bufio.Reader
+	w            *bufio.Writer
+	hijacked     bool
+	// watching is closed when the watcher started by watchClose is done;
+	// stopping tells it the read error it gets is ours.
+	watching chan struct{}
+	stopping int32
+}
+
+// watchClose cancels the request context when the web server closes the
+// connection, which it does when the client goes away. It reads ahead
+// without consuming anything, so it is only started once the request
+// body has been read.
+func (conn *scgiConn) watchClose() {
+	if _, ok := conn.fd.(net.Conn); !ok {
+		return
+	}
+	conn.watching = make(chan struct{})
+	go func() {
+		defer close(conn.watching)
+		if _, err := conn.reader.Peek(1); err != nil && atomic.LoadInt32(&conn.stopping) == 0 {
+			conn.cancel()
+		}
+	}()
+}
+
+// stopWatching ends the watcher, so the reader can be used again.
+func (conn *scgiConn) stopWatching() {
+	if conn.watching == nil {
+		return
+	}
+	nc := conn.fd.(net.Conn)
+	atomic.StoreInt32(&conn.stopping, 1)
+	nc.SetReadDeadline(aLongTimeAgo)
+	<-conn.watching
+	nc.SetReadDeadline(time.Time{})
+	conn.watching = nil
 }
 
+// aLongTimeAgo is a read deadline that makes a pending read fail at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
 func (conn *scgiConn) WriteHeader(status int) {
 	if !conn.wroteHeaders {
 		conn.wroteHeaders = true
@@ -54,7 +105,7 @@ func (conn *scgiConn) WriteHeader(status int) {
 		}
 
 		buf.WriteString("\r\n")
-		conn.fd.Write(buf.Bytes())
+		conn.w.Write(buf.Bytes())
 	}
 }
 
@@ -71,12 +122,44 @@ func (conn *scgiConn) Write(data []byte) (n int, err error) {
 		return 0, errors.New("Body Not Allowed")
 	}
 
-	return conn.fd.Write(data)
+	n, err = conn.w.Write(data)
+	if err != nil {
+		//the client is gone, cancel the request context
+		conn.cancel()
+	}
+	return n, err
+}
+
+// Flush sends the buffered header and body to the web server.
+func (conn *scgiConn) Flush() {
+	if !conn.wroteHeaders {
+		conn.WriteHeader(200)
+	}
+	if err := conn.w.Flush(); err != nil {
+		conn.cancel()
+	}
+}
+
+// Hijack hands the connection to the caller, which must close it.
+func (conn *scgiConn) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	nc, ok := conn.fd.(net.Conn)
+	if !ok {
+		return nil, nil, ErrHijackNotSupported
+	}
+	if conn.hijacked {
+		return nil, nil, errors.New("SCGI connection already hijacked")
+	}
+	conn.stopWatching()
+	conn.hijacked = true
+	return nc, bufio.NewReadWriter(conn.reader, conn.w), nil
 }
 
 func (conn *scgiConn) Close() { conn.fd.Close() }
 
 func (conn *scgiConn) finishRequest() error {
+	if conn.hijacked {
+		return nil
+	}
 	var buf bytes.Buffer
 	if !conn.wroteHeaders {
 		conn.wroteHeaders = true
@@ -88,12 +171,12 @@ func (conn *scgiConn) finishRequest() error {
 		}
 
 		buf.WriteString("\r\n")
-		conn.fd.Write(buf.Bytes())
+		conn.w.Write(buf.Bytes())
 	}
-	return nil
+	return conn.w.Flush()
 }
 
-func (s *Server) readScgiRequest(fd io.ReadWriteCloser) (*http.Request, error) {
+func (s *Server) readScgiRequest(fd io.ReadWriteCloser) (*http.Request, *bufio.Reader, error) {
 	reader := bufio.NewReader(fd)
 	line, err := reader.ReadString(':')
 	if err != nil {
@@ -106,16 +189,16 @@ func (s *Server) readScgiRequest(fd io.ReadWriteCloser) (*http.Request, error) {
 	headerData := make([]byte, length)
 	_, err = reader.Read(headerData)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	b, err := reader.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// discard the trailing comma
 	if b != ',' {
-		return nil, errors.New("SCGI protocol error: missing comma")
+		return nil, nil, errors.New("SCGI protocol error: missing comma")
 	}
 	headerList := bytes.Split(headerData, []byte{0})
 	headers := map[string]string{}
@@ -124,26 +207,47 @@ func (s *Server) readScgiRequest(fd io.ReadWriteCloser) (*http.Request, error) {
 	}
 	httpReq, err := cgi.RequestFromMap(headers)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if httpReq.ContentLength > 0 {
-		httpReq.Body = &scgiBody{
-			reader: io.LimitReader(reader, httpReq.ContentLength),
-			conn:   fd,
-		}
-	} else {
-		httpReq.Body = &scgiBody{reader: reader, conn: fd}
+	//the body is exactly CONTENT_LENGTH bytes, which SCGI always sends
+	if httpReq.ContentLength < 0 {
+		httpReq.ContentLength = 0
 	}
-	return httpReq, nil
+	httpReq.Body = &scgiBody{
+		reader: io.LimitReader(reader, httpReq.ContentLength),
+		conn:   fd,
+	}
+	return httpReq, reader, nil
 }
 
 func (s *Server) handleScgiRequest(fd io.ReadWriteCloser) {
-	req, err := s.readScgiRequest(fd)
+	req, reader, err := s.readScgiRequest(fd)
 	if err != nil {
-		s.Logger.Println("SCGI error: %q", err.Error())
+		s.Logger.Println("SCGI error:", err.Error())
+		fd.Close()
+		return
+	}
+	c, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	req = req.WithContext(c)
+	sc := scgiConn{
+		fd:      fd,
+		req:     req,
+		headers: make(map[string][]string),
+		cancel:  cancel,
+		reader:  reader,
+		w:       bufio.NewWriter(fd),
+	}
+	if body := req.Body.(*scgiBody); req.ContentLength > 0 {
+		body.done = sc.watchClose
+	} else {
+		sc.watchClose()
 	}
-	sc := scgiConn{fd, req, make(map[string][]string), false}
 	s.routeHandler(req, &sc)
+	if sc.hijacked {
+		return
+	}
+	sc.stopWatching()
 	sc.finishRequest()
 	fd.Close()
 }
@@ -176,5 +280,4 @@ func (s *Server) listenAndServeScgi(addr string) error {
 		}
 		go s.handleScgiRequest(fd)
 	}
-	return nil
 }
@@ -0,0 +1,135 @@
+package goweb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config values written as "enc:<base64>" are secrets encrypted with
+// AES-GCM, the base64 holding the nonce followed by the sealed value.
+// They are decrypted when the config is loaded, after the environment
+// overrides, so the rest of the program only sees plaintext. Use
+// EncryptConfigValue, or the goweb-encrypt command, to produce them.
+const secretPrefix = "enc:"
+
+// ConfigSecretKey returns the key enc: values are decrypted with: the
+// GOWEB_SECRET_KEY environment variable or, failing that, the contents
+// of the file named by GOWEB_SECRET_KEYFILE. Either holds 16, 24 or 32
+// bytes in base64, choosing AES-128, AES-192 or AES-256. The key is nil
+// if neither variable is set.
+func ConfigSecretKey() ([]byte, error) {
+	encoded := os.Getenv("GOWEB_SECRET_KEY")
+	if encoded == "" {
+		name := os.Getenv("GOWEB_SECRET_KEYFILE")
+		if name == "" {
+			return nil, nil
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("secret key is not valid base64")
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("secret key is %d bytes, want 16, 24 or 32", len(key))
+}
+
+// EncryptConfigValue encrypts plaintext with key and returns it in the
+// enc:<base64> form a config file can hold.
+func EncryptConfigValue(key []byte, plaintext string) (string, error) {
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptConfigValue reverses EncryptConfigValue. value must carry the
+// enc: prefix.
+func DecryptConfigValue(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return "", errors.New("value is not encrypted")
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[len(secretPrefix):]))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value: wrong key or corrupted value")
+	}
+	return string(plaintext), nil
+}
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptSecrets replaces every enc: value in c and its sections with
+// its plaintext. The key is only read if there is something to decrypt.
+func (c *Config) decryptSecrets() error {
+	var key []byte
+	var keyErr error
+	keyRead := false
+	decrypt := func(section *Config) error {
+		for k, v := range section.keys {
+			if !strings.HasPrefix(v, secretPrefix) {
+				continue
+			}
+			if !keyRead {
+				key, keyErr = ConfigSecretKey()
+				if keyErr == nil && key == nil {
+					keyErr = errors.New("encrypted value but no key; set GOWEB_SECRET_KEY or GOWEB_SECRET_KEYFILE")
+				}
+				keyRead = true
+			}
+			if keyErr != nil {
+				return section.configError(k, keyErr.Error())
+			}
+			plaintext, err := DecryptConfigValue(key, v)
+			if err != nil {
+				return section.configError(k, err.Error())
+			}
+			section.keys[k] = plaintext
+		}
+		return nil
+	}
+	for _, name := range c.order {
+		if err := decrypt(c.sections[strings.ToLower(name)]); err != nil {
+			return err
+		}
+	}
+	if c.global != nil {
+		if err := decrypt(c.global); err != nil {
+			return err
+		}
+	}
+	return decrypt(c)
+}
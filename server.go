@@ -2,6 +2,7 @@ package goweb
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
@@ -9,42 +10,150 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
-	"path"
 	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
+// ServerConfig holds the server settings, decoded with Config.Decode from
+// the keys before the first section of the config file, or from a
+// [server] section.
 type ServerConfig struct {
-	StaticDir    string
-	CookieDomain string
-	CookieSecret string
-	RecoverPanic bool
-	Profiler     bool
+	StaticDir    string `config:"staticdir"`
+	CookieDomain string `config:"cookiedomain"`
+	CookieSecret string `config:"cookiesecret"`
+	RecoverPanic bool   `config:"recoverpanic" default:"true"`
+	Profiler     bool   `config:"profiler"`
+	// TrustRequestId keeps the X-Request-ID sent by any client instead of
+	// generating a new one. Only enable it behind a proxy that sets it.
+	TrustRequestId bool `config:"trustrequestid"`
+	// TrustedProxies lists the CIDRs of proxies whose forwarding headers
+	// are believed; set it with Server.SetTrustedProxies.
+	TrustedProxies []string `config:"trustedproxies"`
+	// DevMode re-reads templates on every render and lets DevOverlay
+	// serve files from disk.
+	DevMode bool `config:"devmode"`
+	// TemplateDir, when set, is loaded with Server.Templates at startup.
+	TemplateDir string `config:"templatedir"`
+	// RequestTimeout, when set, is the deadline put on every request's
+	// context, e.g. "30s"; a bare number is seconds. It is checked before
+	// each middleware and the handler, which answer 503 once it has
+	// passed; a handler already running is not interrupted and should
+	// watch ctx.Request.Context() for long work.
+	RequestTimeout time.Duration `config:"requesttimeout"`
 }
 
 type Server struct {
+	// Config is replaced, not changed in place, when a watched config
+	// file is reloaded.
 	Config *ServerConfig
-	routes []route
-	Logger *log.Logger
-	Env    map[string]interface{}
-	l      net.Listener
+	conf   *Config
+	// mu guards Config, conf, statics, staticOptions, roots and
+	// trustedNets against reloads.
+	mu      sync.RWMutex
+	routes  []route
+	statics []*staticMount
+	// staticOptions holds the static* config keys, used for mounts
+	// without options of their own.
+	staticOptions StaticOptions
+	// roots are the "/" mounts for StaticDir, or the default static
+	// directories, made once so that the static cache recognises them.
+	// rootsDir is the StaticDir they were made for.
+	roots       []*staticMount
+	rootsDir    string
+	templates   templateSet
+	middlewares []Middleware
+	Logger      *log.Logger
+	Env         map[string]interface{}
+	Hub         *Hub
+	l           net.Listener
+	trustedNets []*net.IPNet
 }
 
+// Middleware wraps the handling of a request. It is called with the
+// request context and a next function that continues with the rest of
+// the chain; a middleware that does not call next stops the request.
+type Middleware func(ctx *Context, next func())
+
+// NewServer creates a server configured from config, which may be nil
+// to use the defaults. If the [Redis] section sets redisconn, the Hub
+// publishes through a RedisBroker.
 func NewServer(config *Config) *Server {
-	return &Server{
-		Config: &ServerConfig{
-			StaticDir:    config.GetString("staticdir", ""),
-			CookieDomain: config.GetString("cookiedomain", ""),
-			CookieSecret: config.GetString("cookiesecret", ""),
-			RecoverPanic: config.GetBool("recoverpanic", true),
-			Profiler:     config.GetBool("profiler", false),
-		},
+	if config == nil {
+		config = newConfig("", "")
+	}
+	keys := config.serverKeys()
+	sc := &ServerConfig{}
+	err := keys.Decode("", sc)
+	s := &Server{
+		Config: sc,
+		conf:   config,
 		Logger: log.New(os.Stdout, "", log.Ldate|log.Ltime),
 		Env:    map[string]interface{}{},
+		Hub:    NewHub(),
+	}
+	if err != nil {
+		s.Logger.Println("Error in config:", err)
+	}
+	if len(sc.TrustedProxies) > 0 {
+		if err := s.SetTrustedProxies(sc.TrustedProxies); err != nil {
+			s.Logger.Println("Error in trustedproxies:", err)
+		}
+	}
+	if s.Config.TemplateDir != "" {
+		s.loadTemplateDir(s.Config.TemplateDir)
+	}
+	s.staticOptions = staticOptionsFromConfig(keys, nil)
+	opts := s.staticOptions
+	opts.Manifest = keys.GetString("staticmanifest", "")
+	s.statics = sortMounts(s.staticMountsFromConfig(config, opts))
+	if keys.GetBool("compress", false) {
+		level := keys.GetInt("compresslevel", 0)
+		if level != 0 && !validCompressLevel(level) {
+			s.Logger.Println("Error in config: compresslevel must be between -2 and 9, got", level)
+		}
+		opts := &CompressOptions{
+			Level:        level,
+			MinSize:      keys.GetInt("compressminsize", 0),
+			ContentTypes: keys.GetList("compresstypes", nil),
+		}
+		s.Use(Compress(opts))
+	}
+	if keys.GetBool("etag", false) {
+		s.Use(ETag(keys.GetBool("weaketag", false)))
+	}
+	if redis := config.Section("Redis"); redis.GetString("redisconn", "") != "" {
+		b, err := NewRedisBrokerFromSection(redis)
+		if err == nil {
+			err = s.Hub.SetBroker(b)
+		}
+		if err != nil {
+			s.Logger.Println("Error starting Redis broker:", err)
+		}
+	}
+	return s
+}
+
+// AppConfig returns the config the server was created from, or last
+// reloaded, for reading application sections such as
+// config.Section("Mysql").
+func (s *Server) AppConfig() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.conf == nil {
+		return newConfig("", "")
 	}
+	return s.conf
+}
+
+// settings returns the current ServerConfig.
+func (s *Server) settings() *ServerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Config
 }
 
 func (s *Server) initServer() {
@@ -56,6 +165,10 @@ func (s *Server) initServer() {
 	if s.Logger == nil {
 		s.Logger = log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	}
+
+	if s.Hub == nil {
+		s.Hub = NewHub()
+	}
 }
 
 type route struct {
@@ -63,6 +176,7 @@ type route struct {
 	cr      *regexp.Regexp
 	method  string
 	handler reflect.Value
+	ws      *WebSocketOptions
 }
 
 func (s *Server) addRoute(r string, method string, handler interface{}) {
@@ -73,10 +187,10 @@ func (s *Server) addRoute(r string, method string, handler interface{}) {
 	}
 
 	if fv, ok := handler.(reflect.Value); ok {
-		s.routes = append(s.routes, route{r, cr, method, fv})
+		s.routes = append(s.routes, route{r: r, cr: cr, method: method, handler: fv})
 	} else {
 		fv := reflect.ValueOf(handler)
-		s.routes = append(s.routes, route{r, cr, method, fv})
+		s.routes = append(s.routes, route{r: r, cr: cr, method: method, handler: fv})
 	}
 }
 
@@ -108,6 +222,54 @@ func (s *Server) Match(method string, route string, handler interface{}) {
 	s.addRoute(route, method, handler)
 }
 
+// Use appends a middleware to the chain run for every request.
+func (s *Server) Use(m Middleware) {
+	s.middlewares = append(s.middlewares, m)
+}
+
+// runMiddleware calls the middleware at index i, or handler once the
+// chain is exhausted. The chain stops early if the request was aborted or
+// its context is done. A panic is recovered at the level it happens, so
+// the middlewares around it still see the 500 go out.
+func (s *Server) runMiddleware(ctx *Context, i int, handler func()) {
+	if ctx.aborted {
+		return
+	}
+	if err := ctx.Request.Context().Err(); err != nil {
+		if err == context.DeadlineExceeded {
+			ctx.Abort(503, "Service Unavailable")
+		}
+		return
+	}
+	defer s.recoverMiddleware(ctx)
+	if i == len(s.middlewares) {
+		handler()
+		return
+	}
+	s.middlewares[i](ctx, func() {
+		s.runMiddleware(ctx, i+1, handler)
+	})
+}
+
+// recoverMiddleware is deferred by runMiddleware. It stops a Halt from
+// unwinding further and, with RecoverPanic, turns any other panic into a
+// 500 like safelyCall does for handlers.
+func (s *Server) recoverMiddleware(ctx *Context) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	if _, ok := err.(haltSignal); ok {
+		// ctx.Halt called from a middleware
+		return
+	}
+	if !s.settings().RecoverPanic {
+		panic(err)
+	}
+	s.logPanic(ctx, "Middleware crashed with error", err)
+	ctx.Abort(500, "Server Error")
+}
+
 func (s *Server) Run(addr string) {
 	s.initServer()
 
@@ -163,29 +325,39 @@ func (s *Server) Close() {
 	}
 }
 
-func (s *Server) safelyCall(function reflect.Value, args []reflect.Value) (resp []reflect.Value, e interface{}) {
+func (s *Server) safelyCall(ctx *Context, function reflect.Value, args []reflect.Value) (resp []reflect.Value, e interface{}) {
 	defer func() {
 		if err := recover(); err != nil {
-			if !s.Config.RecoverPanic {
+			if _, ok := err.(haltSignal); ok {
+				// the handler called ctx.Halt
+				resp = nil
+				return
+			}
+			if !s.settings().RecoverPanic {
 				// go back to panic
 				panic(err)
 			} else {
 				e = err
 				resp = nil
-				s.Logger.Println("Handler crashed with error", err)
-				for i := 1; ; i += 1 {
-					_, file, line, ok := runtime.Caller(i)
-					if !ok {
-						break
-					}
-					s.Logger.Println(file, line)
-				}
+				s.logPanic(ctx, "Handler crashed with error", err)
 			}
 		}
 	}()
 	return function.Call(args), nil
 }
 
+// logPanic logs a recovered panic and the stack it was raised on.
+func (s *Server) logPanic(ctx *Context, msg string, err interface{}) {
+	ctx.Log(msg, err)
+	for i := 1; ; i += 1 {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		ctx.Log(file, line)
+	}
+}
+
 func requiresContext(handlerType reflect.Type) bool {
 	//if the method doesn't take arguments, no
 	if handlerType.NumIn() == 0 {
@@ -205,34 +377,22 @@ func requiresContext(handlerType reflect.Type) bool {
 	return false
 }
 
-func (s *Server) tryServingFile(name string, req *http.Request, w http.ResponseWriter) bool {
-	//try to serve a static file
-	if s.Config.StaticDir != "" {
-		staticFile := path.Join(s.Config.StaticDir, name)
-		if fileExists(staticFile) {
-			http.ServeFile(w, req, staticFile)
-			return true
-		}
-	} else {
-		for _, staticDir := range defaultStaticDirs {
-			staticFile := path.Join(staticDir, name)
-			if fileExists(staticFile) {
-				http.ServeFile(w, req, staticFile)
-				return true
-			}
-		}
-	}
-	return false
-}
-
 // the main route handler in web.go
 func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
+	if timeout := s.settings().RequestTimeout; timeout > 0 {
+		c, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(c)
+	}
 	requestPath := req.URL.Path
-	ctx := Context{req, map[string]string{}, s, w}
+	rw := newResponseWriter(w)
+	ctx := &Context{Request: req, Params: map[string]string{}, Server: s, ResponseWriter: rw, writer: rw}
+	s.assignRequestId(ctx)
+	req = ctx.Request
 
 	//log the request
 	var logEntry bytes.Buffer
-	fmt.Fprintf(&logEntry, "\033[32;1m%s %s\033[0m", req.Method, requestPath)
+	fmt.Fprintf(&logEntry, "[%s] %s \033[32;1m%s %s\033[0m", ctx.requestId, ctx.ClientIP(), req.Method, requestPath)
 
 	//ignore errors from ParseForm because it's usually harmless.
 	req.ParseForm()
@@ -240,7 +400,8 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 		for k, v := range req.Form {
 			ctx.Params[k] = v[0]
 		}
-		fmt.Fprintf(&logEntry, "\n\033[37;1mParams: %v\033[0m\n", ctx.Params)
+		//on the same line, so it stays with the request ID
+		fmt.Fprintf(&logEntry, " \033[37;1mParams: %v\033[0m", ctx.Params)
 	}
 	ctx.Server.Logger.Print(logEntry.String())
 
@@ -249,8 +410,27 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 	tm := time.Now().UTC()
 	ctx.SetHeader("Date", webTime(tm), true)
 
+	defer func() {
+		if ctx.stream != nil {
+			ctx.stream.Close()
+		}
+		for _, sub := range ctx.subs {
+			sub.Unsubscribe()
+		}
+		ctx.Log(rw.Status(), rw.Size(), "bytes", time.Since(tm))
+	}()
+	s.runMiddleware(ctx, 0, func() {
+		s.dispatch(ctx)
+	})
+}
+
+// dispatch serves a static file or calls the matching route handler.
+func (s *Server) dispatch(ctx *Context) {
+	req := ctx.Request
+	requestPath := req.URL.Path
+
 	if req.Method == "GET" || req.Method == "HEAD" {
-		if s.tryServingFile(requestPath, req, w) {
+		if s.tryServingFile(ctx, false) {
 			return
 		}
 	}
@@ -275,21 +455,34 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 			continue
 		}
 
+		if route.ws != nil {
+			if !isWebSocketUpgrade(req) {
+				continue
+			}
+			s.serveWebSocket(ctx, route, match[1:])
+			return
+		}
+
 		var args []reflect.Value
 		handlerType := route.handler.Type()
 		if requiresContext(handlerType) {
-			args = append(args, reflect.ValueOf(&ctx))
+			args = append(args, reflect.ValueOf(ctx))
 		}
 		for _, arg := range match[1:] {
 			args = append(args, reflect.ValueOf(arg))
 		}
 
-		ret, err := s.safelyCall(route.handler, args)
+		ret, err := s.safelyCall(ctx, route.handler, args)
+		if ctx.stream != nil {
+			//stop any heartbeat before the response is touched again, by
+			//Abort here or by the middlewares as they unwind
+			ctx.stream.Close()
+		}
 		if err != nil {
 			//there was an error or panic while calling the handler
 			ctx.Abort(500, "Server Error")
 		}
-		if len(ret) == 0 {
+		if len(ret) == 0 || ctx.aborted {
 			return
 		}
 
@@ -302,19 +495,20 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 		} else if sval.Kind() == reflect.Slice && sval.Type().Elem().Kind() == reflect.Uint8 {
 			content = sval.Interface().([]byte)
 		}
+		if ctx.writeEtag(content) {
+			return
+		}
 		ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
 		_, err = ctx.ResponseWriter.Write(content)
 		if err != nil {
-			ctx.Server.Logger.Println("Error during write: ", err)
+			ctx.Log("Error during write: ", err)
 		}
 		return
 	}
 
-	// try serving index.html or index.htm
+	// try serving an index file such as index.html
 	if req.Method == "GET" || req.Method == "HEAD" {
-		if s.tryServingFile(path.Join(requestPath, "index.html"), req, w) {
-			return
-		} else if s.tryServingFile(path.Join(requestPath, "index.htm"), req, w) {
+		if s.tryServingFile(ctx, true) || s.tryServingFallback(ctx) {
 			return
 		}
 	}
@@ -0,0 +1,119 @@
+package goweb
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewarePanic(t *testing.T) {
+	transports := map[string]transport{
+		"ServeHTTP": serveHTTPTransport,
+		"SCGI":      scgiTransport,
+	}
+	for name, fetch := range transports {
+		t.Run(name, func(t *testing.T) {
+			s := NewServer(nil)
+			s.Logger = log.New(io.Discard, "", 0)
+			var outer int
+			s.Use(func(ctx *Context, next func()) {
+				next()
+				outer = ctx.writer.Status()
+			})
+			s.Use(func(ctx *Context, next func()) {
+				if ctx.Request.URL.Path == "/boom" {
+					panic("boom")
+				}
+				next()
+			})
+			s.Get("/ok", func() string { return "ok" })
+
+			if status, body := fetch(t, s, "/boom"); status != 500 || body != "Server Error" {
+				t.Errorf("got %d %q, want a 500", status, body)
+			}
+			if outer != 500 {
+				t.Errorf("outer middleware saw %d, want 500", outer)
+			}
+			if status, body := fetch(t, s, "/ok"); status != 200 || body != "ok" {
+				t.Errorf("after the panic: got %d %q", status, body)
+			}
+		})
+	}
+}
+
+func TestServerConfigKeys(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"cookiedomain = a.example\n[Mysql]\ncookiedomain = db\n", "a.example"},
+		{"[Mysql]\ncookiedomain = db\n", ""},
+		{"cookiedomain = a.example\n[server]\ncookiedomain = b.example\n", "b.example"},
+		{"[Server]\ncookiedomain = b.example\n[Redis]\nrecoverpanic = maybe\n", "b.example"},
+	}
+	for _, tt := range tests {
+		config, err := ParseConfig(strings.NewReader(tt.src), "app.conf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewServer(config)
+		if s.Config.CookieDomain != tt.want || !s.Config.RecoverPanic {
+			t.Errorf("%q: got CookieDomain %q, RecoverPanic %v", tt.src, s.Config.CookieDomain, s.Config.RecoverPanic)
+		}
+		// the flattened view still answers for every section
+		if got := config.GetString("cookiedomain", ""); got == "" {
+			t.Errorf("%q: GetString lost the key", tt.src)
+		}
+	}
+}
+
+// TestScgiClientGone closes the web server side of an SCGI connection
+// while the handler waits, as nginx does when the client disconnects.
+func TestScgiClientGone(t *testing.T) {
+	for _, body := range []string{"", "a=1"} {
+		s := NewServer(nil)
+		s.Logger = log.New(io.Discard, "", 0)
+		cancelled := make(chan bool, 1)
+		handler := func(ctx *Context) {
+			select {
+			case <-ctx.Request.Context().Done():
+				cancelled <- true
+			case <-time.After(5 * time.Second):
+				cancelled <- false
+			}
+		}
+		s.Get("/wait", handler)
+		s.Post("/wait", handler)
+
+		client, server := net.Pipe()
+		go s.handleScgiRequest(server)
+		method := "GET"
+		if body != "" {
+			method = "POST"
+		}
+		var headers bytes.Buffer
+		for _, kv := range [][2]string{
+			{"CONTENT_LENGTH", strconv.Itoa(len(body))},
+			{"SCGI", "1"},
+			{"REQUEST_METHOD", method},
+			{"REQUEST_URI", "/wait"},
+			{"SERVER_PROTOCOL", "HTTP/1.1"},
+			{"CONTENT_TYPE", "application/x-www-form-urlencoded"},
+		} {
+			headers.WriteString(kv[0] + "\x00" + kv[1] + "\x00")
+		}
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Write([]byte(strconv.Itoa(headers.Len()) + ":" + headers.String() + "," + body)); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		if !<-cancelled {
+			t.Errorf("%s: the request context was not cancelled", method)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package goweb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrStreamClosed = errors.New("goweb: event stream closed")
+
+// Event is a single Server-Sent Event. Only Data is required.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry, when set, tells the client how long to wait before
+	// reconnecting.
+	Retry time.Duration
+}
+
+// EventStream writes Server-Sent Events to the client. It is safe for
+// concurrent use, so a heartbeat can run alongside the handler.
+type EventStream struct {
+	ctx         *Context
+	lastEventId string
+	mu          sync.Mutex
+	closed      bool
+}
+
+// SSE turns the response into a text/event-stream and returns a writer
+// for it. The stream ends when the handler returns or the client goes
+// away, which Done reports. Long-lived streams should run a Heartbeat so
+// proxies do not time them out.
+func (ctx *Context) SSE() *EventStream {
+	h := ctx.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	//ask nginx not to buffer the stream
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	ctx.ResponseWriter.WriteHeader(200)
+	ctx.Flush()
+
+	es := &EventStream{ctx: ctx, lastEventId: ctx.Request.Header.Get("Last-Event-ID")}
+	ctx.stream = es
+	return es
+}
+
+// LastEventID returns the ID the client last received before
+// reconnecting, so the handler can resume from there.
+func (es *EventStream) LastEventID() string {
+	return es.lastEventId
+}
+
+// Done is closed when the client disconnects or the request ends.
+func (es *EventStream) Done() <-chan struct{} {
+	return es.ctx.Request.Context().Done()
+}
+
+// Send writes an event with the given type and data; event may be empty.
+func (es *EventStream) Send(event string, data string) error {
+	return es.SendEvent(&Event{Event: event, Data: data})
+}
+
+func (es *EventStream) SendEvent(e *Event) error {
+	var buf strings.Builder
+	if e.ID != "" {
+		buf.WriteString("id: " + sseField(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		buf.WriteString("event: " + sseField(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return es.write(buf.String())
+}
+
+// Retry tells the client how long to wait before reconnecting.
+func (es *EventStream) Retry(d time.Duration) error {
+	return es.write("retry: " + strconv.FormatInt(int64(d/time.Millisecond), 10) + "\n\n")
+}
+
+// Comment writes a comment line, which clients ignore.
+func (es *EventStream) Comment(text string) error {
+	return es.write(": " + sseField(text) + "\n\n")
+}
+
+// Heartbeat writes a comment every interval until the stream ends, which
+// keeps proxies from timing out idle streams and detects dead clients.
+func (es *EventStream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-es.Done():
+				return
+			case <-ticker.C:
+				if es.Comment("ping") != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close ends the stream; later writes return ErrStreamClosed. It is
+// called automatically when the handler returns.
+func (es *EventStream) Close() {
+	es.mu.Lock()
+	es.closed = true
+	es.mu.Unlock()
+}
+
+func (es *EventStream) write(s string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.closed {
+		return ErrStreamClosed
+	}
+	if err := es.ctx.Request.Context().Err(); err != nil {
+		es.closed = true
+		return err
+	}
+	if _, err := es.ctx.ResponseWriter.Write([]byte(s)); err != nil {
+		es.closed = true
+		return err
+	}
+	es.ctx.Flush()
+	return nil
+}
+
+// sseField keeps a value on one line.
+func sseField(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,662 @@
+package goweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StaticOptions configures a static mount. In a [static:<prefix>]
+// config section each field is read from the key in its config tag.
+type StaticOptions struct {
+	// CacheControl, when set, is sent with every file from the mount.
+	CacheControl string `config:"cachecontrol"`
+	// IndexFiles are tried in order for requests that name a directory
+	// and match no route. The default is index.html, then index.htm.
+	IndexFiles []string `config:"indexfiles"`
+	// NoIndex turns index files off.
+	NoIndex bool `config:"noindex"`
+	// Precompressed serves foo.js.br or foo.js.gz in place of foo.js when
+	// the client accepts that encoding and the file exists.
+	Precompressed bool `config:"precompressed"`
+	// Manifest is the path, inside the mount, of a JSON asset manifest
+	// mapping logical names to fingerprinted files, e.g.
+	// {"app.js": "app.3f9a1c.js"}. Vite-style entries with a "file" field
+	// work too. Files named in it are sent with an immutable Cache-Control
+	// and can be linked from templates with {{asset "app.js"}}.
+	Manifest string `config:"manifest"`
+
+	// AllowDotfiles serves files and directories whose names start with a
+	// dot, such as .git or .env. They are hidden by default.
+	AllowDotfiles bool `config:"allowdotfiles"`
+	// FollowSymlinks serves symlinks that point outside the mount. By
+	// default a symlink is only followed if it stays inside the mount's
+	// directory. It applies to mounts created with Static.
+	FollowSymlinks bool `config:"followsymlinks"`
+	// DirectoryListing lists directories that have no index file.
+	DirectoryListing bool `config:"listing"`
+	// DenyExtensions lists extensions, such as ".bak" or ".php", that are
+	// never served.
+	DenyExtensions []string `config:"denyext"`
+
+	// Fallback is the path, inside the mount, of a page such as
+	// "index.html" served for GET requests under the mount that match no
+	// route and no file, so a single-page app can route on the client.
+	// Requests whose last path segment has an extension, like a missing
+	// /app.js, still get a 404. The page is sent with Cache-Control
+	// no-cache so a new deploy is picked up straight away.
+	Fallback string `config:"fallback"`
+	// FallbackExclude lists URL prefixes, such as "/api/", that get a 404
+	// instead of the fallback page.
+	FallbackExclude []string `config:"fallbackexclude"`
+
+	// Cache, when set, keeps the mount's small files in memory.
+	Cache *StaticCache `config:"-"`
+}
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+const fallbackCacheControl = "no-cache"
+
+var defaultIndexFiles = []string{"index.html", "index.htm"}
+
+type staticMount struct {
+	prefix string
+	fsys   fs.FS
+	// dir is the on-disk root for mounts created with Static.
+	dir  string
+	opts StaticOptions
+	// fromConfig marks mounts from the static config key, which are
+	// replaced when the config is reloaded.
+	fromConfig bool
+	// manifest maps logical asset names to fingerprinted paths, and
+	// hashed holds those paths.
+	manifest map[string]string
+	hashed   map[string]bool
+}
+
+// Static serves the files under dir at urlPrefix, e.g.
+// s.Static("/assets", "build", nil). Mounts are matched longest prefix
+// first; ServerConfig.StaticDir, or ./static when it is empty, stays
+// mounted at "/" unless a mount replaces it. A nil opts uses the
+// static* settings from the config file.
+func (s *Server) Static(urlPrefix string, dir string, opts *StaticOptions) {
+	s.addStatic(&staticMount{prefix: cleanPrefix(urlPrefix), fsys: os.DirFS(dir), dir: dir}, opts)
+}
+
+// StaticFS serves the files in fsys at urlPrefix, so assets can be
+// compiled into the binary with embed.FS:
+//
+//	//go:embed static
+//	var assets embed.FS
+//
+//	sub, _ := fs.Sub(assets, "static")
+//	s.StaticFS("/", s.DevOverlay("static", sub), nil)
+func (s *Server) StaticFS(urlPrefix string, fsys fs.FS, opts *StaticOptions) {
+	s.addStatic(&staticMount{prefix: cleanPrefix(urlPrefix), fsys: fsys}, opts)
+}
+
+func (s *Server) addStatic(m *staticMount, opts *StaticOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opts != nil {
+		m.opts = *opts
+	} else {
+		m.opts = s.staticOptions
+	}
+	s.loadMount(m)
+	s.statics = sortMounts(append(s.statics, m))
+}
+
+func (s *Server) loadMount(m *staticMount) {
+	if m.opts.Manifest != "" {
+		if err := m.loadManifest(); err != nil {
+			s.Logger.Println("Error loading asset manifest:", err)
+		}
+	}
+}
+
+func sortMounts(mounts []*staticMount) []*staticMount {
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].prefix) > len(mounts[j].prefix)
+	})
+	return mounts
+}
+
+func cleanPrefix(prefix string) string {
+	prefix = path.Clean("/" + prefix)
+	if prefix != "/" {
+		prefix = strings.TrimSuffix(prefix, "/")
+	}
+	return prefix
+}
+
+// staticMounts returns the mounts to try, including the fallback "/"
+// mount for StaticDir or the default static directories.
+func (s *Server) staticMounts() []*staticMount {
+	s.mu.RLock()
+	mounts, roots := s.statics, s.roots
+	fresh := roots != nil && s.rootsDir == s.Config.StaticDir
+	s.mu.RUnlock()
+	for _, m := range mounts {
+		if m.prefix == "/" {
+			return mounts
+		}
+	}
+	if !fresh {
+		roots = s.rootMounts()
+	}
+	return append(mounts[:len(mounts):len(mounts)], roots...)
+}
+
+// rootMounts makes the "/" mounts for the current StaticDir and static
+// options, unless another request just did. The staticmanifest is loaded
+// from whichever of them has it.
+func (s *Server) rootMounts() []*staticMount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := s.Config.StaticDir
+	if s.roots != nil && s.rootsDir == dir {
+		return s.roots
+	}
+	dirs := []string{dir}
+	if dir == "" {
+		dirs = defaultStaticDirs()
+	}
+	opts := s.staticOptions
+	if s.conf != nil {
+		opts.Manifest = s.conf.serverKeys().GetString("staticmanifest", "")
+	}
+	var roots []*staticMount
+	var manifestErr error
+	loaded := false
+	for _, dir := range dirs {
+		m := &staticMount{prefix: "/", fsys: os.DirFS(dir), dir: dir, opts: opts}
+		if opts.Manifest != "" {
+			if err := m.loadManifest(); err != nil {
+				manifestErr = err
+			} else {
+				loaded = true
+			}
+		}
+		roots = append(roots, m)
+	}
+	if manifestErr != nil && !loaded {
+		s.Logger.Println("Error loading asset manifest:", manifestErr)
+	}
+	s.roots, s.rootsDir = roots, dir
+	return roots
+}
+
+// relativePath strips the mount prefix from urlPath, reporting whether
+// the path is under the mount.
+func (m *staticMount) relativePath(urlPath string) (string, bool) {
+	if m.prefix == "/" {
+		return urlPath, true
+	}
+	if urlPath == m.prefix {
+		return "/", true
+	}
+	if strings.HasPrefix(urlPath, m.prefix+"/") {
+		return urlPath[len(m.prefix):], true
+	}
+	return "", false
+}
+
+func (m *staticMount) indexFiles() []string {
+	if m.opts.NoIndex {
+		return nil
+	}
+	if len(m.opts.IndexFiles) > 0 {
+		return m.opts.IndexFiles
+	}
+	return defaultIndexFiles
+}
+
+// tryServingFile serves the request from a static mount. With index set
+// it looks for the mount's index files in the requested directory
+// instead of the file itself.
+func (s *Server) tryServingFile(ctx *Context, index bool) bool {
+	for _, m := range s.staticMounts() {
+		name, ok := m.relativePath(ctx.Request.URL.Path)
+		if !ok {
+			continue
+		}
+		candidates := []string{name}
+		if index {
+			candidates = nil
+			for _, f := range m.indexFiles() {
+				candidates = append(candidates, path.Join(name, f))
+			}
+		}
+		for _, name := range candidates {
+			if m.permitted(name) && m.serveFile(ctx, name) {
+				return true
+			}
+		}
+		if index && m.opts.DirectoryListing && m.allowed(name) && m.listDirectory(ctx, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed applies the mount's dotfile, extension and symlink policies.
+func (m *staticMount) allowed(name string) bool {
+	return m.permitted(name) && m.resolvesInside(name)
+}
+
+// permitted applies the policies that depend only on the name.
+func (m *staticMount) permitted(name string) bool {
+	name = fsPath(name)
+	if !m.opts.AllowDotfiles {
+		for _, part := range strings.Split(name, "/") {
+			if strings.HasPrefix(part, ".") && part != "." {
+				return false
+			}
+		}
+	}
+	ext := path.Ext(name)
+	for _, deny := range m.opts.DenyExtensions {
+		if ext != "" && strings.EqualFold(ext, deny) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvesInside applies the symlink policy. It touches the disk, so
+// the static cache keeps its answer with the file.
+func (m *staticMount) resolvesInside(name string) bool {
+	if m.dir != "" && !m.opts.FollowSymlinks {
+		return insideDir(m.dir, fsPath(name))
+	}
+	return true
+}
+
+// insideDir reports whether name, resolved through any symlinks, stays
+// under dir. Missing files pass; opening them fails later anyway.
+func insideDir(dir string, name string) bool {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// listDirectory writes an HTML listing of the directory name, leaving out
+// entries the mount would refuse to serve.
+func (m *staticMount) listDirectory(ctx *Context, name string) bool {
+	entries, err := fs.ReadDir(m.fsys, fsPath(name))
+	if err != nil {
+		return false
+	}
+	urlPath := ctx.Request.URL.Path
+	var buf bytes.Buffer
+	buf.WriteString("<!doctype html>\n<meta charset=\"utf-8\">\n<pre>\n")
+	for _, e := range entries {
+		entry := e.Name()
+		if !m.allowed(path.Join(name, entry)) {
+			continue
+		}
+		if e.IsDir() {
+			entry += "/"
+		}
+		href := (&url.URL{Path: path.Join(urlPath, entry)}).String()
+		if e.IsDir() {
+			href += "/"
+		}
+		fmt.Fprintf(&buf, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(entry))
+	}
+	buf.WriteString("</pre>\n")
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+	ctx.SetHeader("Content-Length", strconv.Itoa(buf.Len()), true)
+	ctx.ResponseWriter.Write(buf.Bytes())
+	return true
+}
+
+// tryServingFallback serves the Fallback page of the first mount the
+// request falls under, for requests nothing else handled.
+func (s *Server) tryServingFallback(ctx *Context) bool {
+	urlPath := ctx.Request.URL.Path
+	if path.Ext(urlPath) != "" {
+		return false
+	}
+	for _, m := range s.staticMounts() {
+		if _, ok := m.relativePath(urlPath); !ok {
+			continue
+		}
+		if m.opts.Fallback == "" {
+			return false
+		}
+		for _, prefix := range m.opts.FallbackExclude {
+			if strings.HasPrefix(urlPath, prefix) {
+				return false
+			}
+		}
+		return m.permitted(m.opts.Fallback) && m.serve(ctx, m.opts.Fallback, fallbackCacheControl)
+	}
+	return false
+}
+
+// serveFile serves name from the mount if it is a regular file.
+func (m *staticMount) serveFile(ctx *Context, name string) bool {
+	cacheControl := m.opts.CacheControl
+	if m.hashed[fsPath(name)] {
+		cacheControl = immutableCacheControl
+	}
+	return m.serve(ctx, name, cacheControl)
+}
+
+func (m *staticMount) serve(ctx *Context, name string, cacheControl string) bool {
+	if m.opts.Cache != nil {
+		if served, stream := m.serveCached(ctx, name, cacheControl); !stream {
+			return served
+		}
+	}
+	if !m.resolvesInside(name) {
+		return false
+	}
+	f, info, ok := m.open(name)
+	if !ok {
+		return false
+	}
+	defer f.Close()
+
+	if m.opts.Precompressed {
+		addVary(ctx.Header(), "Accept-Encoding")
+		if cf, cinfo, encoding, ok := m.openPrecompressed(ctx, name); ok {
+			defer cf.Close()
+			ctx.SetHeader("Content-Encoding", encoding, true)
+			f, info = cf, cinfo
+		}
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return false
+		}
+		content = bytes.NewReader(b)
+	}
+	if cacheControl != "" {
+		ctx.SetHeader("Cache-Control", cacheControl, true)
+	}
+	ctx.setContentTypeFor(name)
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, info.Name(), info.ModTime(), content)
+	return true
+}
+
+// open opens name if it is a regular file in the mount.
+func (m *staticMount) open(name string) (fs.File, fs.FileInfo, bool) {
+	f, err := m.fsys.Open(fsPath(name))
+	if err != nil {
+		return nil, nil, false
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+// openPrecompressed opens the .br or .gz sibling of name that the client
+// accepts, preferring brotli.
+func (m *staticMount) openPrecompressed(ctx *Context, name string) (fs.File, fs.FileInfo, string, bool) {
+	accept := ctx.Request.Header.Get("Accept-Encoding")
+	for _, enc := range []struct{ coding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if !acceptsEncoding(accept, enc.coding) || !m.allowed(name+enc.ext) {
+			continue
+		}
+		if f, info, ok := m.open(name + enc.ext); ok {
+			return f, info, enc.coding, true
+		}
+	}
+	return nil, nil, "", false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding.
+func acceptsEncoding(accept string, coding string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		c := strings.TrimSpace(fields[0])
+		if !strings.EqualFold(c, coding) && c != "*" {
+			continue
+		}
+		q := "1"
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				q = f[2:]
+			}
+		}
+		if v, err := strconv.ParseFloat(q, 64); err == nil && v > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *staticMount) loadManifest() error {
+	b, err := fs.ReadFile(m.fsys, fsPath(m.opts.Manifest))
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	m.manifest = map[string]string{}
+	m.hashed = map[string]bool{}
+	for logical, v := range raw {
+		var file string
+		if json.Unmarshal(v, &file) != nil {
+			var entry struct {
+				File string `json:"file"`
+			}
+			if json.Unmarshal(v, &entry) != nil || entry.File == "" {
+				continue
+			}
+			file = entry.File
+		}
+		m.manifest[strings.TrimPrefix(logical, "/")] = fsPath(file)
+		m.hashed[fsPath(file)] = true
+	}
+	return nil
+}
+
+// AssetURL returns the URL of the fingerprinted file a manifest maps name
+// to, or name under "/" when no manifest knows it. Templates call it as
+// {{asset "app.js"}}.
+func (s *Server) AssetURL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	for _, m := range s.staticMounts() {
+		if file, ok := m.manifest[name]; ok {
+			return path.Join(m.prefix, file)
+		}
+	}
+	return "/" + name
+}
+
+// fsPath turns a URL path into an fs.FS name.
+func fsPath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// DevOverlay returns fsys with the on-disk dir laid over it when
+// ServerConfig.DevMode is set, so edits show up without a rebuild.
+// Otherwise it returns fsys unchanged.
+func (s *Server) DevOverlay(dir string, fsys fs.FS) fs.FS {
+	if !s.settings().DevMode {
+		return fsys
+	}
+	return overlayFS{os.DirFS(dir), fsys}
+}
+
+// overlayFS opens files from upper, falling back to lower.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.lower.Open(name)
+}
+
+var defaultStaticDirsOnce sync.Once
+var defaultStaticDirsList []string
+
+// defaultStaticDirs returns the static folders next to the executable
+// and in the working directory, used when no StaticDir is configured.
+func defaultStaticDirs() []string {
+	defaultStaticDirsOnce.Do(func() {
+		wd, _ := os.Getwd()
+		arg0 := path.Clean(os.Args[0])
+
+		var exeFile string
+		if strings.HasPrefix(arg0, "/") {
+			exeFile = arg0
+		} else {
+			exeFile = path.Join(wd, arg0)
+		}
+		parent, _ := path.Split(exeFile)
+		defaultStaticDirsList = append(defaultStaticDirsList, path.Join(parent, "static"))
+		defaultStaticDirsList = append(defaultStaticDirsList, path.Join(wd, "static"))
+	})
+	return defaultStaticDirsList
+}
+
+// staticSectionPrefix starts the names of the config sections that set
+// up a single mount.
+const staticSectionPrefix = "static:"
+
+// staticSection returns the mount prefix a section is for, if it is a
+// [static:<prefix>] section.
+func staticSection(name string) (string, bool) {
+	if len(name) <= len(staticSectionPrefix) || !strings.EqualFold(name[:len(staticSectionPrefix)], staticSectionPrefix) {
+		return "", false
+	}
+	return cleanPrefix(strings.TrimSpace(name[len(staticSectionPrefix):])), true
+}
+
+// staticMountsFromConfig reads the mounts in the static config key, a
+// comma-separated list of prefix:dir pairs such as
+// "/assets:build, /uploads:/data/uploads", and in [static:<prefix>]
+// sections. A section gives the mount at its prefix its own options,
+// over the static* keys in opts, and adds the mount if it has a dir key:
+//
+//	[static:/assets]
+//	dir = build
+//	cachecontrol = public, max-age=86400
+//	indexfiles = index.html, default.htm
+func (s *Server) staticMountsFromConfig(config *Config, opts StaticOptions) []*staticMount {
+	var prefixes []string
+	dirs := map[string]string{}
+	add := func(prefix string, dir string) {
+		if _, ok := dirs[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		dirs[prefix] = dir
+	}
+	for _, entry := range strings.Split(config.serverKeys().GetString("static", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			s.Logger.Printf("Error in static mount %q\n", entry)
+			continue
+		}
+		add(cleanPrefix(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]))
+	}
+	sections := map[string]*Config{}
+	for _, name := range config.Sections() {
+		prefix, ok := staticSection(name)
+		if !ok {
+			continue
+		}
+		section := config.Section(name)
+		sections[prefix] = section
+		if dir := section.GetString("dir", ""); dir != "" {
+			add(prefix, dir)
+		} else if _, ok := dirs[prefix]; !ok {
+			s.Logger.Printf("Error in config: [%s] has no dir\n", name)
+		}
+	}
+
+	var mounts []*staticMount
+	for _, prefix := range prefixes {
+		mountOpts := opts
+		if section := sections[prefix]; section != nil {
+			if err := section.Decode("", &mountOpts); err != nil {
+				s.Logger.Println("Error in config:", err)
+			}
+		}
+		m := &staticMount{
+			prefix:     prefix,
+			fsys:       os.DirFS(dirs[prefix]),
+			dir:        dirs[prefix],
+			opts:       mountOpts,
+			fromConfig: true,
+		}
+		s.loadMount(m)
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+// staticOptionsFromConfig reads the static* keys. cache is kept if the
+// cache settings still match it.
+func staticOptionsFromConfig(config *Config, cache *StaticCache) StaticOptions {
+	opts := StaticOptions{
+		CacheControl:     config.GetString("staticcachecontrol", ""),
+		Precompressed:    config.GetBool("staticprecompressed", false),
+		AllowDotfiles:    config.GetBool("staticallowdotfiles", false),
+		FollowSymlinks:   config.GetBool("staticfollowsymlinks", false),
+		DirectoryListing: config.GetBool("staticlisting", false),
+		DenyExtensions:   config.GetList("staticdenyext", nil),
+		Fallback:         config.GetString("staticfallback", ""),
+		FallbackExclude:  config.GetList("staticfallbackexclude", nil),
+	}
+	var sizes struct {
+		Size    ByteSize `config:"staticcache"`
+		MaxFile ByteSize `config:"staticcachemaxfile"`
+	}
+	config.Decode("", &sizes)
+	if size, maxFile := int64(sizes.Size), int64(sizes.MaxFile); size > 0 {
+		if cache != nil && cache.maxBytes == size && (cache.maxFile == maxFile || maxFile <= 0) {
+			opts.Cache = cache
+		} else {
+			opts.Cache = NewStaticCache(size, maxFile)
+		}
+	}
+	return opts
+}
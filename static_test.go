@@ -0,0 +1,276 @@
+package goweb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secretContent = "TOP SECRET"
+
+// newTraversalServer mounts a directory holding a public file next to
+// files the mount must refuse: a dotfile, a denied extension and
+// symlinks out of the directory. secret.txt sits outside it.
+func newTraversalServer(t *testing.T) *Server {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{root, filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(root, "public.txt"):     "public",
+		filepath.Join(root, "sub", "ok.txt"):  "ok",
+		filepath.Join(root, ".env"):           secretContent,
+		filepath.Join(root, "sub", ".htpass"): secretContent,
+		filepath.Join(root, "backup.bak"):     secretContent,
+		filepath.Join(base, "secret.txt"):     secretContent,
+		filepath.Join(outside, "secret.txt"):  secretContent,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(nil)
+	s.Logger = log.New(io.Discard, "", 0)
+	s.Config.StaticDir = root
+	s.Static("/files", root, &StaticOptions{DenyExtensions: []string{".bak"}})
+	return s
+}
+
+var traversalPaths = []string{
+	"/../secret.txt",
+	"/files/../secret.txt",
+	"/files/../../secret.txt",
+	"/files/%2e%2e/secret.txt",
+	"/files/%2e%2e/%2e%2e/secret.txt",
+	"/files/..%2fsecret.txt",
+	"/files/..%2f..%2fsecret.txt",
+	"/files/sub/../../secret.txt",
+	"/files/..\\secret.txt",
+	"/%2e%2e/outside/secret.txt",
+	"/files/.env",
+	"/files/%2eenv",
+	"/files/sub/.htpass",
+	"/.env",
+	"/files/backup.bak",
+	"/files/backup.BAK",
+	"/files/escape.txt",
+	"/files/escdir/secret.txt",
+	"/escape.txt",
+	"/escdir/secret.txt",
+}
+
+// A transport fetches a raw request URI and returns the status and body.
+type transport func(t *testing.T, s *Server, uri string) (int, string)
+
+func TestStaticTraversal(t *testing.T) {
+	transports := map[string]transport{
+		"ServeHTTP": serveHTTPTransport,
+		"TLS":       tlsTransport,
+		"SCGI":      scgiTransport,
+		"FastCGI":   fcgiTransport,
+	}
+	for name, fetch := range transports {
+		t.Run(name, func(t *testing.T) {
+			s := newTraversalServer(t)
+			for _, uri := range []string{"/files/public.txt", "/files/sub/ok.txt", "/public.txt"} {
+				if status, body := fetch(t, s, uri); status != 200 || body == "" {
+					t.Errorf("%s: got %d %q, want the file", uri, status, body)
+				}
+			}
+			for _, uri := range traversalPaths {
+				status, body := fetch(t, s, uri)
+				if status == 200 || strings.Contains(body, secretContent) {
+					t.Errorf("%s: got %d %q", uri, status, body)
+				}
+			}
+		})
+	}
+}
+
+func serveHTTPTransport(t *testing.T, s *Server, uri string) (int, string) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", uri, nil))
+	return w.Code, w.Body.String()
+}
+
+// tlsTransport serves s the way RunTLS does and sends the request line
+// as written, so the client does not clean the path.
+func tlsTransport(t *testing.T, s *Server, uri string) (int, string) {
+	mux := http.NewServeMux()
+	mux.Handle("/", s)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.StartTLS()
+	defer srv.Close()
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n", uri)
+	return readHTTPResponse(t, bufio.NewReader(conn))
+}
+
+func scgiTransport(t *testing.T, s *Server, uri string) (int, string) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleScgiRequest(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var headers bytes.Buffer
+	for _, kv := range [][2]string{
+		{"CONTENT_LENGTH", "0"},
+		{"SCGI", "1"},
+		{"REQUEST_METHOD", "GET"},
+		{"REQUEST_URI", uri},
+		{"SERVER_PROTOCOL", "HTTP/1.1"},
+		{"HTTP_HOST", "example.com"},
+	} {
+		headers.WriteString(kv[0] + "\x00" + kv[1] + "\x00")
+	}
+	req := strconv.Itoa(headers.Len()) + ":" + headers.String() + ","
+	if _, err := client.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	return readHTTPResponse(t, bufio.NewReader(client))
+}
+
+func readHTTPResponse(t *testing.T, br *bufio.Reader) (int, string) {
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// fcgiTransport sends one request over FastCGI, as a web server in
+// front of RunFcgi would.
+func fcgiTransport(t *testing.T, s *Server, uri string) (int, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go fcgi.Serve(l, s)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const (
+		typeBeginRequest = 1
+		typeEndRequest   = 3
+		typeParams       = 4
+		typeStdin        = 5
+		typeStdout       = 6
+	)
+	record := func(typ byte, content []byte) {
+		head := []byte{1, typ, 0, 1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(head[4:], uint16(len(content)))
+		conn.Write(append(head, content...))
+	}
+	var params bytes.Buffer
+	for _, kv := range [][2]string{
+		{"REQUEST_METHOD", "GET"},
+		{"REQUEST_URI", uri},
+		{"SERVER_PROTOCOL", "HTTP/1.1"},
+		{"HTTP_HOST", "example.com"},
+	} {
+		params.WriteByte(byte(len(kv[0])))
+		params.WriteByte(byte(len(kv[1])))
+		params.WriteString(kv[0] + kv[1])
+	}
+	record(typeBeginRequest, []byte{0, 1, 0, 0, 0, 0, 0, 0})
+	record(typeParams, params.Bytes())
+	record(typeParams, nil)
+	record(typeStdin, nil)
+
+	var stdout bytes.Buffer
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(conn, head[:]); err != nil {
+			t.Fatal(err)
+		}
+		content := make([]byte, int(binary.BigEndian.Uint16(head[4:]))+int(head[6]))
+		if _, err := io.ReadFull(conn, content); err != nil {
+			t.Fatal(err)
+		}
+		if head[1] == typeEndRequest {
+			break
+		}
+		if head[1] == typeStdout {
+			stdout.Write(content[:binary.BigEndian.Uint16(head[4:])])
+		}
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(&stdout))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := 200
+	if s := header.Get("Status"); s != "" {
+		status, _ = strconv.Atoi(strings.Fields(s)[0])
+	}
+	body, _ := io.ReadAll(tp.R)
+	return status, string(body)
+}
+
+func TestStaticDirManifest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.3f2a.js":   "bundle",
+		"manifest.json": `{"app.js": "app.3f2a.js"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := ParseConfig(strings.NewReader("staticdir = "+dir+"\nstaticmanifest = manifest.json\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(config)
+	s.Logger = log.New(io.Discard, "", 0)
+
+	if got := s.AssetURL("app.js"); got != "/app.3f2a.js" {
+		t.Errorf("AssetURL = %q, want /app.3f2a.js", got)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/app.3f2a.js", nil))
+	if w.Code != 200 || !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("got %d, Cache-Control %q, want an immutable file", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
@@ -0,0 +1,252 @@
+package goweb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staticCacheRecheck is how often a cached file is compared with the
+// file on disk. Changes show up within this long.
+const staticCacheRecheck = time.Second
+
+// StaticCache keeps small static files in memory, together with their
+// ETag and a gzip encoded copy for compressible types, so hot files are
+// served without touching the disk. It is bounded by total size and
+// evicts the least recently used files first. A cache can be shared by
+// several mounts through StaticOptions.Cache.
+type StaticCache struct {
+	maxBytes int64
+	maxFile  int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[staticCacheKey]*list.Element
+}
+
+// staticCacheKey names a file by the directory it is in rather than by
+// mount, so a mount rebuilt on reload picks up the entries of the one it
+// replaces instead of leaving them to age out. Mounts without a
+// directory, from StaticFS, are told apart by mount.
+type staticCacheKey struct {
+	dir string
+	// follow is set for mounts that follow symlinks out of dir, whose
+	// entries the others must not be served.
+	follow bool
+	mount  *staticMount
+	name   string
+}
+
+func newStaticCacheKey(m *staticMount, name string) staticCacheKey {
+	if m.dir != "" {
+		return staticCacheKey{dir: m.dir, follow: m.opts.FollowSymlinks, name: fsPath(name)}
+	}
+	return staticCacheKey{mount: m, name: fsPath(name)}
+}
+
+type cachedFile struct {
+	key     staticCacheKey
+	modtime time.Time
+	size    int64
+	checked time.Time
+	data    []byte
+	etag    string
+	// sniffed is the detected Content-Type, for names without a known
+	// extension.
+	sniffed string
+
+	gzOnce sync.Once
+	gz     []byte
+}
+
+// NewStaticCache creates a cache holding up to maxBytes of file data.
+// Files larger than maxFileSize are always streamed from disk; zero
+// means 256KB.
+func NewStaticCache(maxBytes int64, maxFileSize int64) *StaticCache {
+	if maxFileSize <= 0 {
+		maxFileSize = 256 << 10
+	}
+	return &StaticCache{
+		maxBytes: maxBytes,
+		maxFile:  maxFileSize,
+		lru:      list.New(),
+		items:    map[staticCacheKey]*list.Element{},
+	}
+}
+
+// get returns name from m, loading it if needed. ok is false if name is
+// not a regular file or is a symlink the mount refuses to follow; a nil
+// entry with ok set means it is too large to cache. The symlink check
+// is repeated only when the entry is.
+func (c *StaticCache) get(m *staticMount, name string) (*cachedFile, bool) {
+	key := newStaticCacheKey(m, name)
+	now := time.Now()
+
+	c.mu.Lock()
+	if el, found := c.items[key]; found {
+		e := el.Value.(*cachedFile)
+		c.lru.MoveToFront(el)
+		if now.Sub(e.checked) < staticCacheRecheck {
+			c.mu.Unlock()
+			return e, true
+		}
+		c.mu.Unlock()
+		info, err := fs.Stat(m.fsys, key.name)
+		if err == nil && info.ModTime().Equal(e.modtime) && info.Size() == e.size && m.resolvesInside(name) {
+			c.mu.Lock()
+			e.checked = now
+			c.mu.Unlock()
+			return e, true
+		}
+		c.remove(e)
+	} else {
+		c.mu.Unlock()
+	}
+
+	if !m.resolvesInside(name) {
+		return nil, false
+	}
+	f, info, ok := m.open(name)
+	if !ok {
+		return nil, false
+	}
+	defer f.Close()
+	if info.Size() > c.maxFile || info.Size() > c.maxBytes {
+		return nil, true
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	e := &cachedFile{
+		key:     key,
+		modtime: info.ModTime(),
+		size:    info.Size(),
+		checked: now,
+		data:    data,
+		etag:    fmt.Sprintf(`"%x"`, sha1.Sum(data)),
+		sniffed: http.DetectContentType(data),
+	}
+	c.mu.Lock()
+	if el, found := c.items[key]; found {
+		//loaded concurrently
+		c.lru.Remove(el)
+		c.size -= el.Value.(*cachedFile).bytes()
+	}
+	c.items[key] = c.lru.PushFront(e)
+	c.size += e.bytes()
+	c.evict()
+	c.mu.Unlock()
+	return e, true
+}
+
+func (c *StaticCache) remove(e *cachedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.items[e.key]; found && el.Value == e {
+		c.lru.Remove(el)
+		delete(c.items, e.key)
+		c.size -= e.bytes()
+	}
+}
+
+// evict drops the least recently used files until the cache fits. Called
+// with c.mu held.
+func (c *StaticCache) evict() {
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		e := el.Value.(*cachedFile)
+		c.lru.Remove(el)
+		delete(c.items, e.key)
+		c.size -= e.bytes()
+	}
+}
+
+// bytes is the memory charged for the entry. Called with c.mu held.
+func (e *cachedFile) bytes() int64 {
+	return int64(len(e.data) + len(e.gz))
+}
+
+// gzipped returns the gzip encoding of the file, computed on first use,
+// or nil if it would not be smaller.
+func (c *StaticCache) gzipped(e *cachedFile) []byte {
+	e.gzOnce.Do(func() {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(e.data)
+		zw.Close()
+		if buf.Len() >= len(e.data) {
+			return
+		}
+		c.mu.Lock()
+		e.gz = buf.Bytes()
+		if el, found := c.items[e.key]; found && el.Value == e {
+			c.size += int64(len(e.gz))
+			c.evict()
+		}
+		c.mu.Unlock()
+	})
+	return e.gz
+}
+
+// serveCached serves name from the mount's cache. stream is set, and
+// nothing served, when the file is too large to cache and should be
+// streamed from disk instead; a missing file, or one the mount may not
+// serve, is neither served nor streamed.
+func (m *staticMount) serveCached(ctx *Context, name string, cacheControl string) (served bool, stream bool) {
+	c := m.opts.Cache
+	e, ok := c.get(m, name)
+	if e == nil {
+		return false, ok
+	}
+	h := ctx.Header()
+	data, etag, encoding := e.data, e.etag, ""
+
+	if m.opts.Precompressed {
+		addVary(h, "Accept-Encoding")
+		accept := ctx.Request.Header.Get("Accept-Encoding")
+		for _, enc := range []struct{ coding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+			if !acceptsEncoding(accept, enc.coding) || !m.permitted(name+enc.ext) {
+				continue
+			}
+			if ce, ok := c.get(m, name+enc.ext); ok && ce != nil {
+				data, etag, encoding = ce.data, ce.etag, enc.coding
+				break
+			}
+		}
+	}
+
+	ctx.setContentTypeFor(name)
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", e.sniffed)
+	}
+	if encoding == "" && len(e.data) >= 1024 && compressibleType(h.Get("Content-Type"), defaultCompressTypes) {
+		addVary(h, "Accept-Encoding")
+		if acceptsEncoding(ctx.Request.Header.Get("Accept-Encoding"), "gzip") {
+			if gz := c.gzipped(e); gz != nil {
+				data, etag, encoding = gz, e.etag[:len(e.etag)-1]+`-gzip"`, "gzip"
+			}
+		}
+	}
+
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+	h.Set("ETag", etag)
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, name, e.modtime, bytes.NewReader(data))
+	return true, false
+}
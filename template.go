@@ -0,0 +1,161 @@
+package goweb
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+type templateSet struct {
+	mu       sync.RWMutex
+	fsys     fs.FS
+	patterns []string
+	funcs    template.FuncMap
+	tmpl     *template.Template
+}
+
+// Templates loads the html/template files in fsys matching patterns, or
+// every .html file when no pattern is given. Templates are named by their
+// path in fsys, e.g. "admin/users.html". In DevMode they are re-read on
+// every Render.
+func (s *Server) Templates(fsys fs.FS, patterns ...string) error {
+	ts := &s.templates
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.fsys = fsys
+	ts.patterns = patterns
+	tmpl, err := ts.parse(s.builtinTemplateFuncs())
+	if err != nil {
+		return err
+	}
+	ts.tmpl = tmpl
+	return nil
+}
+
+// builtinTemplateFuncs are available to every template. The per-request
+// ones are placeholders here, bound to the Context in Render.
+func (s *Server) builtinTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"asset":     s.AssetURL,
+		"csrfToken": func() string { return "" },
+		"csrfField": func() template.HTML { return "" },
+	}
+}
+
+// templateFuncs binds the per-request template funcs to ctx.
+func (ctx *Context) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": ctx.CsrfToken,
+		"csrfField": ctx.CsrfField,
+	}
+}
+
+// TemplateFuncs adds functions available to every template. Templates
+// already loaded are parsed again so they can use them.
+func (s *Server) TemplateFuncs(funcs template.FuncMap) error {
+	ts := &s.templates
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.funcs == nil {
+		ts.funcs = template.FuncMap{}
+	}
+	for name, fn := range funcs {
+		ts.funcs[name] = fn
+	}
+	if ts.fsys == nil {
+		return nil
+	}
+	tmpl, err := ts.parse(s.builtinTemplateFuncs())
+	if err != nil {
+		return err
+	}
+	ts.tmpl = tmpl
+	return nil
+}
+
+func (ts *templateSet) parse(builtins template.FuncMap) (*template.Template, error) {
+	var names []string
+	if len(ts.patterns) == 0 {
+		err := fs.WalkDir(ts.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.HasSuffix(name, ".html") {
+				names = append(names, name)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range ts.patterns {
+		matches, err := fs.Glob(ts.fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+
+	root := template.New("").Funcs(builtins).Funcs(ts.funcs)
+	for _, name := range names {
+		b, err := fs.ReadFile(ts.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := root.New(name).Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+func (s *Server) lookupTemplates() (*template.Template, error) {
+	ts := &s.templates
+	if s.settings().DevMode {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		if ts.fsys != nil {
+			tmpl, err := ts.parse(s.builtinTemplateFuncs())
+			if err != nil {
+				return nil, err
+			}
+			ts.tmpl = tmpl
+		}
+		return ts.tmpl, nil
+	}
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.tmpl, nil
+}
+
+// Render executes the named template with data and writes it as HTML.
+func (ctx *Context) Render(name string, data interface{}) {
+	tmpl, err := ctx.Server.lookupTemplates()
+	if err == nil && (tmpl == nil || tmpl.Lookup(name) == nil) {
+		err = fs.ErrNotExist
+	}
+	if err == nil {
+		//the shared set is never executed, so it can be cloned per request
+		tmpl, err = tmpl.Clone()
+	}
+	var buf bytes.Buffer
+	if err == nil {
+		err = tmpl.Funcs(ctx.templateFuncs()).ExecuteTemplate(&buf, name, data)
+	}
+	if err != nil {
+		ctx.Log("template error", name, err)
+		ctx.Abort(500, "Server Error")
+		return
+	}
+	ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+	ctx.ResponseWriter.Write(buf.Bytes())
+}
+
+// loadTemplateDir loads the templatedir config key.
+func (s *Server) loadTemplateDir(dir string) {
+	if err := s.Templates(os.DirFS(path.Clean(dir))); err != nil {
+		s.Logger.Println("Error loading templates:", err)
+	}
+}
@@ -2,22 +2,24 @@ package goweb
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
-	"os"
-	"path"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,15 +28,95 @@ type Context struct {
 	Params  map[string]string
 	Server  *Server
 	http.ResponseWriter
+
+	writer    *responseWriter
+	stream    *EventStream
+	subs      []*Subscription
+	requestId string
+	values    map[string]interface{}
+	aborted   bool
+	autoEtag  bool
+	weakEtag  bool
+	csrfToken string
+	csrfField string
 }
 
 func (ctx *Context) WriteString(content string) {
 	ctx.ResponseWriter.Write([]byte(content))
 }
 
+// Abort writes status and body and stops the request: the remaining
+// middleware and the route handler are skipped, and anything written
+// afterwards, including the handler's return value, is discarded.
 func (ctx *Context) Abort(status int, body string) {
+	if ctx.aborted {
+		return
+	}
 	ctx.ResponseWriter.WriteHeader(status)
 	ctx.ResponseWriter.Write([]byte(body))
+	ctx.aborted = true
+	ctx.ResponseWriter = abortedWriter{ctx.ResponseWriter.Header()}
+}
+
+// Halt aborts like Abort and then unwinds the calling handler or
+// middleware so no further code in it runs.
+func (ctx *Context) Halt(status int, body string) {
+	ctx.Abort(status, body)
+	panic(haltSignal{})
+}
+
+// IsAborted reports whether Abort or Halt has been called.
+func (ctx *Context) IsAborted() bool {
+	return ctx.aborted
+}
+
+// haltSignal is the panic value used by Halt; it is recovered by the
+// server rather than treated as a crash.
+type haltSignal struct{}
+
+// abortedWriter discards everything written after Abort.
+type abortedWriter struct {
+	header http.Header
+}
+
+func (w abortedWriter) Header() http.Header         { return w.header }
+func (w abortedWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w abortedWriter) WriteHeader(status int)      {}
+
+// Context returns the request's context, which is canceled when the
+// client goes away or the request deadline passes.
+func (ctx *Context) Context() context.Context {
+	return ctx.Request.Context()
+}
+
+// Set stores a value for the rest of the request, so middleware can
+// pass data to later middleware and to the handler.
+func (ctx *Context) Set(key string, value interface{}) {
+	if ctx.values == nil {
+		ctx.values = map[string]interface{}{}
+	}
+	ctx.values[key] = value
+}
+
+// Get returns a value stored with Set.
+func (ctx *Context) Get(key string) (interface{}, bool) {
+	value, ok := ctx.values[key]
+	return value, ok
+}
+
+func (ctx *Context) GetString(key string) string {
+	value, _ := ctx.values[key].(string)
+	return value
+}
+
+func (ctx *Context) GetInt(key string) int {
+	value, _ := ctx.values[key].(int)
+	return value
+}
+
+func (ctx *Context) GetBool(key string) bool {
+	value, _ := ctx.values[key].(bool)
+	return value
 }
 
 func (ctx *Context) Redirect(status int, url_ string) {
@@ -44,6 +126,8 @@ func (ctx *Context) Redirect(status int, url_ string) {
 }
 
 func (ctx *Context) NotModified() {
+	ctx.Header().Del("Content-Type")
+	ctx.Header().Del("Content-Length")
 	ctx.ResponseWriter.WriteHeader(304)
 }
 
@@ -55,7 +139,7 @@ func (ctx *Context) NotFound(message string) {
 func (ctx *Context) ToJson(o interface{}) {
 	content, err := json.Marshal(o)
 	if err != nil {
-		ctx.Server.Logger.Println("json error")
+		ctx.Log("json error")
 		return
 	}
 	jsoncallback := ctx.Params["jsoncallback"]
@@ -74,7 +158,7 @@ func (ctx *Context) ToJson(o interface{}) {
 func (ctx *Context) ToXml(o interface{}) {
 	content, err := xml.Marshal(o)
 	if err != nil {
-		ctx.Server.Logger.Println("xml error")
+		ctx.Log("xml error")
 		return
 	}
 	ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
@@ -107,7 +191,7 @@ func (ctx *Context) SetHeader(hdr string, val string, unique bool) {
 }
 
 func (ctx *Context) setCookie(cookie *http.Cookie) {
-	ctx.SetHeader("Set-Cookie", cookie.String(), true)
+	ctx.SetHeader("Set-Cookie", cookie.String(), false)
 }
 
 func getCookieSig(key string, val []byte, timestamp string) string {
@@ -125,13 +209,13 @@ func newCookie(name string, value string, age int, domain string) *http.Cookie {
 }
 
 func (ctx *Context) SetCookie(name string, val string, age int) {
-	ctx.setCookie(newCookie(name, val, age, ctx.Server.Config.CookieDomain))
+	ctx.setCookie(newCookie(name, val, age, ctx.Server.settings().CookieDomain))
 }
 
 func (ctx *Context) SetSecureCookie(name string, val string, age int) {
 	//base64 encode the val
-	if len(ctx.Server.Config.CookieSecret) == 0 {
-		ctx.Server.Logger.Println("Secret Key for secure cookies has not been set. Please assign a cookie secret to web.Config.CookieSecret.")
+	if len(ctx.Server.settings().CookieSecret) == 0 {
+		ctx.Log("Secret Key for secure cookies has not been set. Please assign a cookie secret to web.Config.CookieSecret.")
 		return
 	}
 	var buf bytes.Buffer
@@ -141,9 +225,9 @@ func (ctx *Context) SetSecureCookie(name string, val string, age int) {
 	vs := buf.String()
 	vb := buf.Bytes()
 	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	sig := getCookieSig(ctx.Server.Config.CookieSecret, vb, timestamp)
+	sig := getCookieSig(ctx.Server.settings().CookieSecret, vb, timestamp)
 	cookie := strings.Join([]string{vs, timestamp, sig}, "|")
-	ctx.setCookie(newCookie(name, cookie, age, ctx.Server.Config.CookieDomain))
+	ctx.setCookie(newCookie(name, cookie, age, ctx.Server.settings().CookieDomain))
 }
 
 func (ctx *Context) GetSecureCookie(name string) (string, bool) {
@@ -153,12 +237,15 @@ func (ctx *Context) GetSecureCookie(name string) (string, bool) {
 		}
 
 		parts := strings.SplitN(cookie.Value, "|", 3)
+		if len(parts) != 3 {
+			return "", false
+		}
 
 		val := parts[0]
 		timestamp := parts[1]
 		sig := parts[2]
 
-		if getCookieSig(ctx.Server.Config.CookieSecret, []byte(val), timestamp) != sig {
+		if getCookieSig(ctx.Server.settings().CookieSecret, []byte(val), timestamp) != sig {
 			return "", false
 		}
 
@@ -177,79 +264,133 @@ func (ctx *Context) GetSecureCookie(name string) (string, bool) {
 	return "", false
 }
 
-var contextType reflect.Type
-var defaultStaticDirs []string
-var mainServer *Server
+var contextType = reflect.TypeOf(Context{})
 
-func init() {
-	contextType = reflect.TypeOf(Context{})
+var (
+	mainServer     *Server
+	mainServerOnce sync.Once
+)
 
-	wd, _ := os.Getwd()
-	arg0 := path.Clean(os.Args[0])
+// defaultServer returns the server behind the package-level functions,
+// creating it on first use from the file named by ConfigFile. Without
+// the default conf/app.conf it runs on the defaults and GOWEB_
+// variables. Any other config error is fatal; call Configure first to
+// handle it instead.
+func defaultServer() *Server {
+	mainServerOnce.Do(func() {
+		config, err := NewConfig()
+		if errors.Is(err, fs.ErrNotExist) && ConfigFile() == configFile {
+			// still honour environment overrides
+			config, err = ParseConfig(strings.NewReader(""), "")
+		}
+		if err != nil {
+			log.Fatal("goweb: ", err)
+		}
+		mainServer = NewServer(config)
+	})
+	return mainServer
+}
 
-	var exeFile string
-	if strings.HasPrefix(arg0, "/") {
-		exeFile = arg0
-	} else {
-		exeFile = path.Join(wd, arg0)
+// Configure creates the default server from the file named by
+// ConfigFile, reporting a missing or invalid file. It must be called
+// before any other package-level function.
+func Configure() error {
+	config, err := NewConfig()
+	if err != nil {
+		return err
 	}
-	parent, _ := path.Split(exeFile)
-	defaultStaticDirs = append(defaultStaticDirs, path.Join(parent, "static"))
-	defaultStaticDirs = append(defaultStaticDirs, path.Join(wd, "static"))
+	created := false
+	mainServerOnce.Do(func() {
+		mainServer = NewServer(config)
+		created = true
+	})
+	if !created {
+		return errors.New("goweb: Configure called after the default server was created")
+	}
+	return nil
+}
 
-	var config, err = NewConfig()
+// WatchConfig reloads the default server's config file on SIGHUP and,
+// if interval is positive, when it changes. Subscribe to the returned
+// watcher for application sections.
+func WatchConfig(interval time.Duration) (*ConfigWatcher, error) {
+	w, err := NewConfigWatcher(ConfigFile(), interval)
 	if err != nil {
-		//fmt.Println("app.conf not found!")
-		panic(err)
+		return nil, err
 	}
-	mainServer = NewServer(config)
-	return
+	defaultServer().Watch(w)
+	return w, nil
+}
+
+// AppConfig returns the default server's config.
+func AppConfig() *Config {
+	return defaultServer().AppConfig()
 }
 
 func Process(c http.ResponseWriter, req *http.Request) {
-	mainServer.Process(c, req)
+	defaultServer().Process(c, req)
 }
 
 func Run(addr string) {
-	mainServer.Run(addr)
+	defaultServer().Run(addr)
 }
 
 func RunTLS(addr string, config *tls.Config) {
-	mainServer.RunTLS(addr, config)
+	defaultServer().RunTLS(addr, config)
 }
 
 func RunScgi(addr string) {
-	mainServer.RunScgi(addr)
+	defaultServer().RunScgi(addr)
 }
 
 func RunFcgi(addr string) {
-	mainServer.RunFcgi(addr)
+	defaultServer().RunFcgi(addr)
 }
 
 func Close() {
-	mainServer.Close()
+	defaultServer().Close()
 }
 
 func Get(route string, handler interface{}) {
-	mainServer.Get(route, handler)
+	defaultServer().Get(route, handler)
 }
 
 func Post(route string, handler interface{}) {
-	mainServer.addRoute(route, "POST", handler)
+	defaultServer().addRoute(route, "POST", handler)
 }
 
 func Put(route string, handler interface{}) {
-	mainServer.addRoute(route, "PUT", handler)
+	defaultServer().addRoute(route, "PUT", handler)
 }
 
 func Delete(route string, handler interface{}) {
-	mainServer.addRoute(route, "DELETE", handler)
+	defaultServer().addRoute(route, "DELETE", handler)
 }
 
 func Match(method string, route string, handler interface{}) {
-	mainServer.addRoute(route, method, handler)
+	defaultServer().addRoute(route, method, handler)
+}
+
+func WebSocket(route string, handler interface{}) {
+	defaultServer().WebSocket(route, handler)
+}
+
+func Publish(topic string, data []byte) error {
+	return defaultServer().Hub.Publish(topic, data)
+}
+
+func Static(urlPrefix string, dir string, opts *StaticOptions) {
+	defaultServer().Static(urlPrefix, dir, opts)
+}
+
+func StaticFS(urlPrefix string, fsys fs.FS, opts *StaticOptions) {
+	defaultServer().StaticFS(urlPrefix, fsys, opts)
+}
+
+func Use(m Middleware) {
+	defaultServer().Use(m)
 }
 
 func SetLogger(logger *log.Logger) {
-	mainServer.Logger = logger
+	defaultServer().Logger = logger
 }
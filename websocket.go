@@ -0,0 +1,468 @@
+package goweb
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket message types, as used by ReadMessage and WriteMessage.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// WebSocket close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketOptions configures a WebSocket route.
+type WebSocketOptions struct {
+	// CheckOrigin decides whether to accept the handshake. By default a
+	// request with an Origin header is accepted only if the origin's host
+	// is the request host.
+	CheckOrigin func(ctx *Context) bool
+	// MaxMessageSize limits the size of a received message, default 1MB.
+	MaxMessageSize int64
+	// Subprotocols lists the supported subprotocols; the first one the
+	// client offers is chosen.
+	Subprotocols []string
+}
+
+// CloseError is returned by ReadMessage when the peer closes the
+// connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+var ErrWebSocketClosed = errors.New("websocket: connection closed")
+
+// WebSocket registers handler for WebSocket connections on route. The
+// handler takes a *WebSocketConn, optionally preceded by a *Context and
+// followed by the route's submatches, like other handlers:
+//
+//	s.WebSocket("/chat/(.*)", func(ctx *goweb.Context, ws *goweb.WebSocketConn, room string) { ... })
+//
+// Routes are tried in order, so register it before any Get route for the
+// same path; requests that are not WebSocket upgrades fall through to
+// later routes. The connection is closed when the handler returns.
+func (s *Server) WebSocket(route string, handler interface{}) {
+	s.WebSocketWithOptions(route, nil, handler)
+}
+
+func (s *Server) WebSocketWithOptions(route string, opts *WebSocketOptions, handler interface{}) {
+	o := WebSocketOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = 1 << 20
+	}
+	n := len(s.routes)
+	s.addRoute(route, "GET", handler)
+	if len(s.routes) > n {
+		s.routes[n].ws = &o
+	}
+}
+
+func isWebSocketUpgrade(req *http.Request) bool {
+	return req.Method == "GET" &&
+		headerHasToken(req.Header, "Connection", "upgrade") &&
+		headerHasToken(req.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func websocketOriginAllowed(ctx *Context) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, ctx.Host())
+}
+
+// serveWebSocket performs the handshake and runs the route handler.
+func (s *Server) serveWebSocket(ctx *Context, route route, params []string) {
+	opts := route.ws
+	req := ctx.Request
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.SetHeader("Sec-WebSocket-Version", "13", true)
+		ctx.Abort(426, "Unsupported WebSocket version")
+		return
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		ctx.Abort(400, "Bad Sec-WebSocket-Key")
+		return
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = websocketOriginAllowed
+	}
+	if !checkOrigin(ctx) {
+		ctx.Abort(403, "Origin not allowed")
+		return
+	}
+
+	var subprotocol string
+	for _, p := range strings.Split(req.Header.Get("Sec-WebSocket-Protocol"), ",") {
+		p = strings.TrimSpace(p)
+		for _, supported := range opts.Subprotocols {
+			if subprotocol == "" && p == supported {
+				subprotocol = p
+			}
+		}
+	}
+
+	conn, brw, err := ctx.Hijack()
+	if err != nil {
+		ctx.Log("WebSocket hijack failed:", err)
+		ctx.Abort(500, "Server Error")
+		return
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n")
+	if subprotocol != "" {
+		brw.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	brw.WriteString(requestIdHeader + ": " + ctx.requestId + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &WebSocketConn{
+		conn:        conn,
+		br:          brw.Reader,
+		maxSize:     opts.MaxMessageSize,
+		subprotocol: subprotocol,
+	}
+
+	var args []reflect.Value
+	if requiresContext(route.handler.Type()) {
+		args = append(args, reflect.ValueOf(ctx))
+	}
+	args = append(args, reflect.ValueOf(ws))
+	for _, arg := range params {
+		args = append(args, reflect.ValueOf(arg))
+	}
+	if _, e := s.safelyCall(ctx, route.handler, args); e != nil {
+		ws.Close(CloseInternalServerErr, "")
+		return
+	}
+	ws.Close(CloseNormalClosure, "")
+}
+
+// WebSocketConn is a server-side WebSocket connection. One goroutine may
+// read while others write; writes are serialised.
+type WebSocketConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	maxSize     int64
+	subprotocol string
+	pongHandler func(data []byte)
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+// Subprotocol returns the negotiated subprotocol, if any.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets a function called with the payload of each pong,
+// e.g. to extend a read deadline.
+func (c *WebSocketConn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+// ReadMessage returns the next text or binary message. Pings are
+// answered automatically. When the peer closes the connection the close
+// is acknowledged and a *CloseError is returned.
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case 0:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			messageType = opcode
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(data))+int64(len(payload)) > c.maxSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		data = append(data, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid UTF-8")
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// ReadText reads the next message, which must be a text message.
+func (c *WebSocketConn) ReadText() (string, error) {
+	messageType, data, err := c.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	if messageType != TextMessage {
+		return "", c.fail(CloseUnsupportedData, "expected text message")
+	}
+	return string(data), nil
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: bad message type")
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return errors.New("websocket: invalid UTF-8 in text message")
+	}
+	return c.writeFrame(messageType, data)
+}
+
+func (c *WebSocketConn) WriteText(text string) error {
+	return c.WriteMessage(TextMessage, []byte(text))
+}
+
+// Ping sends a ping; the peer's pong is passed to the pong handler.
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: control frame too long")
+	}
+	return c.writeFrame(PingMessage, data)
+}
+
+// Close sends a close frame with code and reason, unless one was
+// already sent, and closes the connection.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	c.conn.Close()
+	return err
+}
+
+func (c *WebSocketConn) sendClose(code int, reason string) error {
+	c.wmu.Lock()
+	sent := c.closeSent
+	c.closeSent = true
+	c.wmu.Unlock()
+	if sent {
+		return nil
+	}
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = append(payload, reason...)
+	}
+	return c.writeFrameLocked(CloseMessage, payload, true)
+}
+
+// handleClose answers the peer's close frame and returns it as an error.
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	code, text := CloseNoStatusReceived, ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "bad close frame")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		text = string(payload[2:])
+		if !validCloseCode(code) {
+			return c.fail(CloseProtocolError, "bad close code")
+		}
+		if !utf8.ValidString(text) {
+			return c.fail(CloseInvalidFramePayloadData, "invalid UTF-8")
+		}
+	}
+	c.sendClose(code, "")
+	c.conn.Close()
+	return &CloseError{Code: code, Text: text}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != CloseNoStatusReceived && code != CloseAbnormalClosure
+	}
+	return false
+}
+
+// fail closes the connection after a protocol violation.
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Text: reason}
+}
+
+func (c *WebSocketConn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	if head[0]&0x70 != 0 {
+		err = c.fail(CloseProtocolError, "reserved bits set")
+		return
+	}
+	if head[1]&0x80 == 0 {
+		err = c.fail(CloseProtocolError, "client frame not masked")
+		return
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		err = c.fail(CloseProtocolError, "bad control frame")
+		return
+	}
+	if length < 0 || length > c.maxSize {
+		err = c.fail(CloseMessageTooBig, "message too big")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	return c.writeFrameLocked(opcode, payload, false)
+}
+
+func (c *WebSocketConn) writeFrameLocked(opcode int, payload []byte, closing bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent && !closing {
+		return ErrWebSocketClosed
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,317 @@
+package goweb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal WebSocket client that can also send the frames
+// a well-behaved client never would.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func newEchoServer(t *testing.T, opts *WebSocketOptions) *httptest.Server {
+	s := NewServer(nil)
+	s.Logger = log.New(io.Discard, "", 0)
+	s.WebSocketWithOptions("/echo", opts, func(ws *WebSocketConn) {
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(messageType, data)
+		}
+	})
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// handshake sends an upgrade request with the given extra header lines
+// and returns the response; the client is usable if it was a 101.
+func handshake(t *testing.T, srv *httptest.Server, extra string) (*wsClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	host := srv.Listener.Addr().String()
+	fmt.Fprintf(conn, "GET /echo HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n%s\r\n", host, extra)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{t, conn, br}, resp
+}
+
+func dialEcho(t *testing.T, srv *httptest.Server) *wsClient {
+	t.Helper()
+	c, resp := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	if resp.StatusCode != 101 {
+		t.Fatalf("handshake: got %d", resp.StatusCode)
+	}
+	return c
+}
+
+func (c *wsClient) send(fin bool, opcode int, payload []byte, masked bool) {
+	c.t.Helper()
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if masked {
+		mask := []byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) read() (opcode int, payload []byte) {
+	c.t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		c.t.Fatal("server frame is masked")
+	}
+	length := int(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return int(head[0] & 0x0f), payload
+}
+
+// expectClose reads the server's close frame and checks its code.
+func (c *wsClient) expectClose(code int) {
+	c.t.Helper()
+	opcode, payload := c.read()
+	if opcode != CloseMessage || len(payload) < 2 {
+		c.t.Fatalf("got opcode %d %q, want a close frame", opcode, payload)
+	}
+	if got := int(binary.BigEndian.Uint16(payload)); got != code {
+		c.t.Fatalf("close code %d, want %d", got, code)
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	srv := newEchoServer(t, nil)
+	_, resp := handshake(t, srv, "Sec-WebSocket-Version: 13\r\n")
+	if resp.StatusCode != 101 {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	// the example key and accept value from RFC 6455 section 1.3
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		t.Errorf("Upgrade = %q", resp.Header.Get("Upgrade"))
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	srv := newEchoServer(t, nil)
+	host := srv.Listener.Addr().String()
+	tests := []struct {
+		origin string
+		want   int
+	}{
+		{"http://" + host, 101},
+		{"https://evil.example", 403},
+		{"http://" + host + ".evil.example", 403},
+	}
+	for _, tt := range tests {
+		_, resp := handshake(t, srv, "Sec-WebSocket-Version: 13\r\nOrigin: "+tt.origin+"\r\n")
+		if resp.StatusCode != tt.want {
+			t.Errorf("Origin %s: got %d, want %d", tt.origin, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestWebSocketVersion(t *testing.T) {
+	srv := newEchoServer(t, nil)
+	_, resp := handshake(t, srv, "Sec-WebSocket-Version: 8\r\n")
+	if resp.StatusCode != 426 || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Fatalf("got %d, Sec-WebSocket-Version %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := newEchoServer(t, nil)
+	c := dialEcho(t, srv)
+	c.send(true, TextMessage, []byte("hello"), true)
+	if opcode, payload := c.read(); opcode != TextMessage || string(payload) != "hello" {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+	big := bytes.Repeat([]byte{7}, 70000)
+	c.send(true, BinaryMessage, big, true)
+	if opcode, payload := c.read(); opcode != BinaryMessage || !bytes.Equal(payload, big) {
+		t.Fatalf("got %d, %d bytes", opcode, len(payload))
+	}
+}
+
+func TestWebSocketUnmasked(t *testing.T) {
+	c := dialEcho(t, newEchoServer(t, nil))
+	c.send(true, TextMessage, []byte("hello"), false)
+	c.expectClose(CloseProtocolError)
+}
+
+func TestWebSocketFragmentation(t *testing.T) {
+	c := dialEcho(t, newEchoServer(t, nil))
+	c.send(false, TextMessage, []byte("hel"), true)
+	// control frames may come between fragments
+	c.send(true, PingMessage, []byte("p"), true)
+	c.send(false, 0, []byte("l"), true)
+	c.send(true, 0, []byte("o"), true)
+	if opcode, payload := c.read(); opcode != PongMessage || string(payload) != "p" {
+		t.Fatalf("got %d %q, want the pong", opcode, payload)
+	}
+	if opcode, payload := c.read(); opcode != TextMessage || string(payload) != "hello" {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+
+	c = dialEcho(t, newEchoServer(t, nil))
+	c.send(true, 0, []byte("stray"), true)
+	c.expectClose(CloseProtocolError)
+
+	c = dialEcho(t, newEchoServer(t, nil))
+	c.send(false, TextMessage, []byte("a"), true)
+	c.send(true, TextMessage, []byte("b"), true)
+	c.expectClose(CloseProtocolError)
+}
+
+func TestWebSocketPing(t *testing.T) {
+	c := dialEcho(t, newEchoServer(t, nil))
+	c.send(true, PingMessage, []byte("are you there"), true)
+	if opcode, payload := c.read(); opcode != PongMessage || string(payload) != "are you there" {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+	// an unsolicited pong is ignored
+	c.send(true, PongMessage, nil, true)
+	c.send(true, TextMessage, []byte("x"), true)
+	if opcode, payload := c.read(); opcode != TextMessage || string(payload) != "x" {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+
+	c = dialEcho(t, newEchoServer(t, nil))
+	c.send(true, PingMessage, bytes.Repeat([]byte("x"), 126), true)
+	c.expectClose(CloseProtocolError)
+}
+
+func TestWebSocketClose(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		want    int
+	}{
+		{closePayload(CloseNormalClosure, "bye"), CloseNormalClosure},
+		{closePayload(4000, ""), 4000},
+		{closePayload(CloseNoStatusReceived, ""), CloseProtocolError},
+		{closePayload(1004, ""), CloseProtocolError},
+		{closePayload(2999, ""), CloseProtocolError},
+		{closePayload(CloseNormalClosure, "\xff"), CloseInvalidFramePayloadData},
+		{[]byte{3}, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		c := dialEcho(t, newEchoServer(t, nil))
+		c.send(true, CloseMessage, tt.payload, true)
+		c.expectClose(tt.want)
+	}
+
+	// an empty close is answered with an empty one
+	c := dialEcho(t, newEchoServer(t, nil))
+	c.send(true, CloseMessage, nil, true)
+	if opcode, payload := c.read(); opcode != CloseMessage || len(payload) != 0 {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+}
+
+func TestWebSocketMaxMessageSize(t *testing.T) {
+	opts := &WebSocketOptions{MaxMessageSize: 8}
+	c := dialEcho(t, newEchoServer(t, opts))
+	c.send(true, TextMessage, []byte("12345678"), true)
+	if _, payload := c.read(); string(payload) != "12345678" {
+		t.Fatalf("got %q", payload)
+	}
+	c.send(true, TextMessage, []byte("123456789"), true)
+	c.expectClose(CloseMessageTooBig)
+
+	// the limit applies to the whole message, not each fragment
+	c = dialEcho(t, newEchoServer(t, opts))
+	c.send(false, TextMessage, []byte("12345"), true)
+	c.send(true, 0, []byte("6789"), true)
+	c.expectClose(CloseMessageTooBig)
+}
+
+func TestWebSocketInvalidUTF8(t *testing.T) {
+	c := dialEcho(t, newEchoServer(t, nil))
+	c.send(true, TextMessage, []byte("ok \xff"), true)
+	c.expectClose(CloseInvalidFramePayloadData)
+
+	// a code point split across fragments is fine
+	c = dialEcho(t, newEchoServer(t, nil))
+	c.send(false, TextMessage, []byte("\xe2\x82"), true)
+	c.send(true, 0, []byte("\xac"), true)
+	if _, payload := c.read(); string(payload) != "€" {
+		t.Fatalf("got %q", payload)
+	}
+
+	// binary messages are not checked
+	c = dialEcho(t, newEchoServer(t, nil))
+	c.send(true, BinaryMessage, []byte{0xff}, true)
+	if opcode, payload := c.read(); opcode != BinaryMessage || !bytes.Equal(payload, []byte{0xff}) {
+		t.Fatalf("got %d %q", opcode, payload)
+	}
+}